@@ -14,8 +14,10 @@ import "fmt"
 import "flag"
 import "time"
 import "bytes"
+import "net/http"
 import "runtime/pprof"
 import "github.com/blahgeek/justvpn"
+import "github.com/blahgeek/justvpn/wire"
 import log "github.com/Sirupsen/logrus"
 
 type LogFormatter struct {
@@ -45,7 +47,9 @@ func main() {
 	need_help := flag.Bool("h", false, "Show help")
 	is_server := flag.Bool("s", false, "Run as server")
 	verbose := flag.Bool("v", false, "More verbose output")
+	use_stdio := flag.Bool("stdio", false, "Use stdin/stdout as the only wire, e.g. as command of pipe wire")
 	cpuprofile := flag.String("cpuprofile", "", "Write cpu profile to file")
+	stats_addr := flag.String("stats", "", "Serve stats of wires as JSON on address, e.g. 127.0.0.1:5480")
 	flag.Parse()
 
 	log.SetFormatter(&LogFormatter{&log.TextFormatter{
@@ -85,6 +89,9 @@ func main() {
 
 	vpn := justvpn.VPN{}
 	defer vpn.Destroy()
+	if *use_stdio {
+		vpn.UseStdio()
+	}
 	if err = vpn.Init(*is_server, json_content); err != nil {
 		log.WithField("error", err).Error("Error initing VPN")
 		return
@@ -92,11 +99,21 @@ func main() {
 
 	vpn.Start()
 
+	if *stats_addr != "" {
+		log.WithField("addr", *stats_addr).Info("Serving stats")
+		go func() {
+			err := http.ListenAndServe(*stats_addr, vpn.StatsHandler())
+			log.WithField("error", err).Error("Stats server stopped")
+		}()
+	}
+
 	signal_chan := make(chan os.Signal, 1)
 	signal.Notify(signal_chan, os.Interrupt)
 
 	select {
 	case <-signal_chan:
-		fmt.Println("CTRL-C Pressed")
+		fmt.Fprintln(os.Stderr, "CTRL-C Pressed")
+	case <-wire.PipeStdioClosed:
+		log.Warning("Stdin closed, exit")
 	}
 }
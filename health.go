@@ -0,0 +1,302 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package justvpn
+
+import "sync"
+import "time"
+import "encoding/binary"
+
+const HEALTH_DEFAULT_INTERVAL = 1000
+const HEALTH_DEFAULT_TIMEOUT = 3000
+const HEALTH_DEFAULT_DEGRADED_LOSS = 0.3
+const HEALTH_DEFAULT_DOWN_AFTER = 3
+const HEALTH_UP_AFTER = 2
+const HEALTH_WINDOW = 10
+const HEALTH_DEFAULT_STATS_INTERVAL = 60000
+const HEALTH_LOW_RATE_MIN_INTERVAL = 10000
+
+// Wires where every probe costs a query or message with limited rate,
+// they are probed less often by default
+var healthLowRateWires = map[string]bool{
+	"dns":  true,
+	"xmpp": true,
+}
+
+// Probes are sent through obfusecators like packets, but never reach TUN.
+// The first byte (IP version) is zero, which is not a valid IP packet.
+// Followed by type, sequence and timestamp of sender, which are echoed in reply
+const PROBE_MAGIC = 0x00
+const PROBE_REQUEST = 0x01
+const PROBE_REPLY = 0x02
+const PROBE_LENGTH = 14
+
+type HealthOptions struct {
+	// Time (in milliseconds) between probes on each wire,
+	// at least 10000 for dns and xmpp unless probe_interval of the wire is set
+	Interval int `json:"interval"`
+	// Time (in milliseconds) to wait for reply, or the probe is lost
+	Timeout int `json:"timeout"`
+	// Loss of recent probes to consider the wire degraded
+	DegradedLoss float64 `json:"degraded_loss"`
+	// Number of probes lost in a row to consider the wire down
+	DownAfter int `json:"down_after"`
+	// Time (in milliseconds) between stats of each wire logged
+	StatsInterval int `json:"stats_interval"`
+	// Do not send probes, all wires are always used.
+	// Probes from the other side are still replied
+	Disabled bool `json:"disabled"`
+}
+
+func (opt *HealthOptions) setDefaults() {
+	if opt.Interval <= 0 {
+		opt.Interval = HEALTH_DEFAULT_INTERVAL
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = HEALTH_DEFAULT_TIMEOUT
+	}
+	if opt.DegradedLoss <= 0 {
+		opt.DegradedLoss = HEALTH_DEFAULT_DEGRADED_LOSS
+	}
+	if opt.DownAfter <= 0 {
+		opt.DownAfter = HEALTH_DEFAULT_DOWN_AFTER
+	}
+	if opt.StatsInterval <= 0 {
+		opt.StatsInterval = HEALTH_DEFAULT_STATS_INTERVAL
+	}
+}
+
+// Interval of probes on wire, probe_interval of it (if set) first
+func (opt *HealthOptions) probeInterval(name string, probe_interval int) time.Duration {
+	interval := opt.Interval
+	if probe_interval > 0 {
+		interval = probe_interval
+	} else if healthLowRateWires[name] && interval < HEALTH_LOW_RATE_MIN_INTERVAL {
+		interval = HEALTH_LOW_RATE_MIN_INTERVAL
+	}
+	return time.Duration(interval) * time.Millisecond
+}
+
+type WireState int
+
+const (
+	WIRE_UP WireState = iota
+	WIRE_DEGRADED
+	WIRE_DOWN
+)
+
+func (s WireState) String() string {
+	switch s {
+	case WIRE_UP:
+		return "up"
+	case WIRE_DEGRADED:
+		return "degraded"
+	case WIRE_DOWN:
+		return "down"
+	}
+	return "unknown"
+}
+
+// Measured by probes of a wire, times are in milliseconds
+type WireStats struct {
+	Wire  string `json:"wire"`
+	State string `json:"state"`
+	// Latest and smoothed round trip time
+	RTT  float64 `json:"rtt"`
+	SRTT float64 `json:"srtt"`
+	// Mean deviation between consecutive RTTs
+	Jitter float64 `json:"jitter"`
+	// Loss of recent probes
+	Loss    float64 `json:"loss"`
+	Sent    uint64  `json:"sent"`
+	Replied uint64  `json:"replied"`
+}
+
+func makeProbe(typ byte, seq uint32, stamp int64) []byte {
+	probe := make([]byte, PROBE_LENGTH)
+	probe[0] = PROBE_MAGIC
+	probe[1] = typ
+	binary.BigEndian.PutUint32(probe[2:], seq)
+	binary.BigEndian.PutUint64(probe[6:], uint64(stamp))
+	return probe
+}
+
+// Return type, sequence and timestamp of probe, false if it's not a probe
+func parseProbe(data []byte) (byte, uint32, int64, bool) {
+	if len(data) != PROBE_LENGTH || data[0] != PROBE_MAGIC {
+		return 0, 0, 0, false
+	}
+	return data[1], binary.BigEndian.Uint32(data[2:]), int64(binary.BigEndian.Uint64(data[6:])), true
+}
+
+func durationToMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// State of one wire driven by probe replies.
+// The wire is down if too many probes are lost in a row, and up again after
+// a few replies in a row. Otherwise it's degraded if too many recent probes are lost.
+// Starts as up (down for server, until probed), so that packets are sent
+// before any probe is replied.
+// RTT and jitter are estimated from sent time of replied probes
+type wireHealth struct {
+	opt *HealthOptions
+
+	lock           sync.Mutex
+	state          WireState
+	next_seq       uint32
+	pending        map[uint32]time.Time // sent time of probes waiting for reply
+	results        []bool               // recent probes, whether replied
+	lost_in_row    int
+	replied_in_row int
+
+	rtt, srtt, jitter time.Duration
+	has_rtt           bool
+	sent, replied     uint64
+}
+
+func newWireHealth(opt *HealthOptions) *wireHealth {
+	return &wireHealth{
+		opt:     opt,
+		pending: make(map[uint32]time.Time),
+	}
+}
+
+func (h *wireHealth) State() WireState {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.state
+}
+
+// Loss of recent probes
+func (h *wireHealth) Loss() float64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.loss()
+}
+
+func (h *wireHealth) loss() float64 {
+	if len(h.results) == 0 {
+		return 0
+	}
+	lost := 0
+	for _, replied := range h.results {
+		if !replied {
+			lost += 1
+		}
+	}
+	return float64(lost) / float64(len(h.results))
+}
+
+func (h *wireHealth) record(replied bool) {
+	h.results = append(h.results, replied)
+	if len(h.results) > HEALTH_WINDOW {
+		h.results = h.results[len(h.results)-HEALTH_WINDOW:]
+	}
+	if replied {
+		h.replied_in_row += 1
+		h.lost_in_row = 0
+	} else {
+		h.lost_in_row += 1
+		h.replied_in_row = 0
+	}
+}
+
+// Update state by results, return previous state
+func (h *wireHealth) update() WireState {
+	prev := h.state
+	switch {
+	case h.lost_in_row >= h.opt.DownAfter:
+		h.state = WIRE_DOWN
+	case h.state == WIRE_DOWN && h.replied_in_row < HEALTH_UP_AFTER:
+	default:
+		if h.state == WIRE_DOWN {
+			// forget losses while it's down
+			h.results = h.results[len(h.results)-h.replied_in_row:]
+		}
+		h.state = WIRE_UP
+		if h.loss() >= h.opt.DegradedLoss {
+			h.state = WIRE_DEGRADED
+		}
+	}
+	return prev
+}
+
+// Probes not replied in time are lost, return previous state
+func (h *wireHealth) Expire(now time.Time) WireState {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	timeout := time.Duration(h.opt.Timeout) * time.Millisecond
+	for seq, sent := range h.pending {
+		if now.Sub(sent) >= timeout {
+			delete(h.pending, seq)
+			h.record(false)
+		}
+	}
+	return h.update()
+}
+
+// Return sequence of new probe
+func (h *wireHealth) Probe(now time.Time) uint32 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	seq := h.next_seq
+	h.next_seq += 1
+	h.pending[seq] = now
+	h.sent += 1
+	return seq
+}
+
+// Reply of probe with our timestamp received,
+// return previous state, and false if it's unexpected.
+// RTT is measured from sent time kept locally (monotonic), the echoed timestamp
+// only tells whether it's the reply of our probe (e.g. not of previous run)
+func (h *wireHealth) Reply(seq uint32, stamp int64, now time.Time) (WireState, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	sent, ok := h.pending[seq]
+	if !ok || sent.UnixNano() != stamp {
+		// late, duplicated or not ours
+		return h.state, false
+	}
+	delete(h.pending, seq)
+	h.replied += 1
+	h.record(true)
+	h.measure(now.Sub(sent))
+	return h.update(), true
+}
+
+// Smoothed RTT like RFC 6298, jitter like RFC 3550
+func (h *wireHealth) measure(rtt time.Duration) {
+	if !h.has_rtt {
+		h.srtt, h.has_rtt = rtt, true
+	} else {
+		h.srtt = (7*h.srtt + rtt) / 8
+
+		delta := rtt - h.rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		h.jitter += (delta - h.jitter) / 16
+	}
+	h.rtt = rtt
+}
+
+// Stats except name of wire
+func (h *wireHealth) Stats() WireStats {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return WireStats{
+		State:   h.state.String(),
+		RTT:     durationToMs(h.rtt),
+		SRTT:    durationToMs(h.srtt),
+		Jitter:  durationToMs(h.jitter),
+		Loss:    h.loss(),
+		Sent:    h.sent,
+		Replied: h.replied,
+	}
+}
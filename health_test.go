@@ -0,0 +1,117 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package justvpn
+
+import "time"
+import "testing"
+
+func TestProbe(t *testing.T) {
+	typ, seq, stamp, ok := parseProbe(makeProbe(PROBE_REPLY, 42, 1445212800000000000))
+	if !ok || typ != PROBE_REPLY || seq != 42 || stamp != 1445212800000000000 {
+		t.Errorf("Bad probe: %v %v %v %v", typ, seq, stamp, ok)
+	}
+	// IPv4 header
+	if _, _, _, ok := parseProbe([]byte{0x45, 0, 0, 14, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}); ok {
+		t.Errorf("IP packet should not be probe")
+	}
+}
+
+func TestWireHealth(t *testing.T) {
+	opt := HealthOptions{}
+	opt.setDefaults()
+	h := newWireHealth(&opt)
+	now := time.Now()
+	timeout := time.Duration(opt.Timeout) * time.Millisecond
+
+	probe := func(replied bool) {
+		seq := h.Probe(now)
+		if replied {
+			h.Reply(seq, now.UnixNano(), now.Add(10*time.Millisecond))
+		}
+		now = now.Add(timeout)
+		h.Expire(now)
+	}
+
+	if h.State() != WIRE_UP {
+		t.Errorf("Should be up at first")
+	}
+	for i := 0; i < 10; i += 1 {
+		probe(i%2 == 0)
+	}
+	if h.State() != WIRE_DEGRADED {
+		t.Errorf("Should be degraded with half lost: %v", h.State())
+	}
+	for i := 0; i < opt.DownAfter; i += 1 {
+		probe(false)
+	}
+	if h.State() != WIRE_DOWN {
+		t.Errorf("Should be down: %v", h.State())
+	}
+	probe(true)
+	if h.State() != WIRE_DOWN {
+		t.Errorf("Should be down until replied in row: %v", h.State())
+	}
+	probe(true)
+	if h.State() != WIRE_UP {
+		t.Errorf("Should be up again: %v", h.State())
+	}
+
+	// late reply is ignored
+	seq := h.Probe(now)
+	h.Expire(now.Add(timeout))
+	if _, ok := h.Reply(seq, now.UnixNano(), now.Add(timeout)); ok {
+		t.Errorf("Late reply should be ignored")
+	}
+	// so is reply not of ours
+	seq = h.Probe(now)
+	if _, ok := h.Reply(seq, now.UnixNano()-1, now); ok {
+		t.Errorf("Reply with other timestamp should be ignored")
+	}
+}
+
+func TestWireHealthRTT(t *testing.T) {
+	opt := HealthOptions{}
+	opt.setDefaults()
+	h := newWireHealth(&opt)
+	now := time.Now()
+
+	for i := 0; i < 50; i += 1 {
+		// 10ms and 30ms in turn
+		rtt := time.Duration(10+20*(i%2)) * time.Millisecond
+		seq := h.Probe(now)
+		h.Reply(seq, now.UnixNano(), now.Add(rtt))
+		now = now.Add(time.Second)
+	}
+	h.Probe(now)
+	h.Expire(now.Add(time.Duration(opt.Timeout) * time.Millisecond))
+
+	stats := h.Stats()
+	if stats.RTT != 30 || stats.SRTT < 15 || stats.SRTT > 25 {
+		t.Errorf("Bad RTT: %v, %v", stats.RTT, stats.SRTT)
+	}
+	if stats.Jitter < 15 || stats.Jitter > 20 {
+		t.Errorf("Bad jitter: %v", stats.Jitter)
+	}
+	if stats.Sent != 51 || stats.Replied != 50 || stats.Loss != 0.1 || stats.State != "up" {
+		t.Errorf("Bad stats: %+v", stats)
+	}
+}
+
+func TestHealthProbeInterval(t *testing.T) {
+	opt := HealthOptions{}
+	opt.setDefaults()
+	if interval := opt.probeInterval("udp", 0); interval != time.Second {
+		t.Errorf("Bad interval of udp: %v", interval)
+	}
+	if interval := opt.probeInterval("dns", 0); interval != 10*time.Second {
+		t.Errorf("Low rate wire should be probed less often: %v", interval)
+	}
+	if interval := opt.probeInterval("xmpp", 2000); interval != 2*time.Second {
+		t.Errorf("Interval of wire should be used: %v", interval)
+	}
+}
@@ -11,6 +11,8 @@ import "io"
 import "fmt"
 import "net"
 import "sync"
+import "time"
+import "net/http"
 import "github.com/blahgeek/justvpn/tun"
 import "github.com/blahgeek/justvpn/wire"
 import "github.com/blahgeek/justvpn/obfs"
@@ -18,6 +20,14 @@ import "encoding/json"
 import log "github.com/Sirupsen/logrus"
 
 const VPN_CHANNEL_BUFFER = 64
+const VPN_WIRE_MIN_RETRY_INTERVAL = 100 * time.Millisecond
+const VPN_WIRE_MAX_RETRY_INTERVAL = 5 * time.Second
+
+// System dependent operations, replaced by tests running without privileges
+var newTun = tun.New
+var applyInterfaceRouter = tun.ApplyInterfaceRouter
+var getWireDefaultGateway = tun.GetWireDefaultGateway
+var applyRouter = tun.ApplyRouter
 
 type VPNOptions struct {
 	Tunnel struct {
@@ -27,6 +37,8 @@ type VPNOptions struct {
 	Wires []struct {
 		Name    string          `json:"name"`
 		Options json.RawMessage `json:"options"`
+		// Time (in milliseconds) between probes on this wire, see HealthOptions
+		ProbeInterval int `json:"probe_interval"`
 	} `json:"wires"`
 	Obfs []struct {
 		Name    string          `json:"name"`
@@ -36,30 +48,66 @@ type VPNOptions struct {
 		Wire []string `json:"wire"`
 		VPN  []string `json:"vpn"`
 	} `json:"route"`
+	Health HealthOptions `json:"health"`
+}
+
+// Wire transport with its health, probes are sent via probe_c
+type vpnWire struct {
+	trans          wire.Transport
+	health         *wireHealth
+	probe_c        chan []byte
+	probe_interval time.Duration
+
+	// closed when the first packet is received
+	received      chan struct{}
+	received_once sync.Once
+}
+
+// Packet read from wire, probes are replied via the same wire
+type wirePacket struct {
+	wire *vpnWire
+	data []byte
 }
 
 type VPN struct {
-	from_tun, to_tun, from_wire, to_wire chan []byte
-	waiter                               sync.WaitGroup
+	from_tun, to_tun, to_wire chan []byte
+	from_wire                 chan wirePacket
+	waiter                    sync.WaitGroup
+	stopped                   chan struct{}
 
 	// saved route rules
 	wire_rules, vpn_rules []net.IPNet
 	wire_gw, vpn_gw       net.IP
 
-	wire_transports []wire.Transport
-	wire_min_mtu    int
+	wire_transports      []wire.Transport
+	wire_probe_intervals []time.Duration
+	wire_min_mtu         int
+	wires                []*vpnWire
+
+	// closed and replaced whenever state of any wire changes
+	health_changed chan struct{}
+	health_lock    sync.Mutex
 
 	tun_trans tun.Tun
 	tun_mtu   int
 
 	obfusecators []obfs.Obfusecator
+	encode_lock  sync.Mutex // packets and probes are encoded in different goroutines
 
 	max_packet_cap int
 
 	is_server bool
+	use_stdio bool
 	options   VPNOptions
 }
 
+// Use stdin/stdout as the only wire instead of configured ones,
+// e.g. when started by command of pipe wire of the other side.
+// Should be called before Init
+func (vpn *VPN) UseStdio() {
+	vpn.use_stdio = true
+}
+
 func (vpn *VPN) initObfusecators() error {
 	vpn.tun_mtu = vpn.wire_min_mtu
 	for _, item := range vpn.options.Obfs {
@@ -80,6 +128,9 @@ func (vpn *VPN) initObfusecators() error {
 }
 
 func (vpn *VPN) initWireTransport() error {
+	if vpn.use_stdio {
+		return vpn.initStdioWireTransport()
+	}
 	vpn.wire_min_mtu = -1
 	for _, item := range vpn.options.Wires {
 		wire_trans, err := wire.New(item.Name, vpn.is_server, item.Options)
@@ -91,14 +142,39 @@ func (vpn *VPN) initWireTransport() error {
 			vpn.wire_min_mtu = mtu
 		}
 		vpn.wire_transports = append(vpn.wire_transports, wire_trans)
+		vpn.wire_probe_intervals = append(vpn.wire_probe_intervals,
+			vpn.options.Health.probeInterval(item.Name, item.ProbeInterval))
+	}
+	log.WithField("mtu", vpn.wire_min_mtu).Info("MTU for wire transport detected")
+	return nil
+}
+
+func (vpn *VPN) initStdioWireTransport() error {
+	// options of pipe wire (e.g. MTU) should be the same on both sides
+	options := json.RawMessage("{}")
+	probe_interval := 0
+	for _, item := range vpn.options.Wires {
+		if item.Name == "pipe" {
+			options = item.Options
+			probe_interval = item.ProbeInterval
+			break
+		}
 	}
+	wire_trans := &wire.PipeTransport{}
+	if err := wire_trans.OpenStdio(vpn.is_server, options); err != nil {
+		return err
+	}
+	vpn.wire_transports = append(vpn.wire_transports, wire_trans)
+	vpn.wire_probe_intervals = append(vpn.wire_probe_intervals,
+		vpn.options.Health.probeInterval("pipe", probe_interval))
+	vpn.wire_min_mtu = wire_trans.MTU()
 	log.WithField("mtu", vpn.wire_min_mtu).Info("MTU for wire transport detected")
 	return nil
 }
 
 func (vpn *VPN) initTunTransport() error {
 	var err error
-	vpn.tun_trans, err = tun.New()
+	vpn.tun_trans, err = newTun()
 	if err != nil {
 		return err
 	}
@@ -127,13 +203,13 @@ func (vpn *VPN) initTunTransport() error {
 	log.WithField("mtu", vpn.tun_mtu).Info("Setting MTU for TUN transport")
 	vpn.tun_trans.SetMTU(vpn.tun_mtu)
 
-	return tun.ApplyInterfaceRouter(vpn.tun_trans)
+	return applyInterfaceRouter(vpn.tun_trans)
 }
 
 func (vpn *VPN) initRouter() error {
 	var err error
 
-	vpn.wire_gw, err = tun.GetWireDefaultGateway()
+	vpn.wire_gw, err = getWireDefaultGateway()
 	if err != nil {
 		return err
 	}
@@ -166,7 +242,7 @@ func (vpn *VPN) initRouter() error {
 		}
 	}
 
-	return tun.ApplyRouter(vpn.wire_rules, vpn.vpn_rules,
+	return applyRouter(vpn.wire_rules, vpn.vpn_rules,
 		vpn.wire_gw, vpn.vpn_gw, false)
 }
 
@@ -175,6 +251,7 @@ func (vpn *VPN) Init(is_server bool, options []byte) error {
 	if err := json.Unmarshal(options, &vpn.options); err != nil {
 		return err
 	}
+	vpn.options.Health.setDefaults()
 
 	if err := vpn.initWireTransport(); err != nil {
 		return err
@@ -273,16 +350,25 @@ func (vpn *VPN) obfsEncode(plain_c <-chan []byte, obfsed_c chan<- []byte) {
 		if !ok {
 			break
 		}
-		for _, obfusecator := range vpn.obfusecators {
-			dst := buffer[:cap(buffer)]
-			enclen := obfusecator.Encode(data, dst)
-			data, buffer = dst[:enclen], data
-		}
+		data, buffer = vpn.encode(data, buffer)
 		obfsed_c <- data
 	}
 }
 
-func (vpn *VPN) obfsDecode(obfsed_c <-chan []byte, plain_c chan<- []byte) {
+// Encode by all obfusecators, return encoded data and buffer to reuse.
+// Both data and buffer should have capacity of max packet
+func (vpn *VPN) encode(data, buffer []byte) ([]byte, []byte) {
+	vpn.encode_lock.Lock()
+	defer vpn.encode_lock.Unlock()
+	for _, obfusecator := range vpn.obfusecators {
+		dst := buffer[:cap(buffer)]
+		enclen := obfusecator.Encode(data, dst)
+		data, buffer = dst[:enclen], data
+	}
+	return data, buffer
+}
+
+func (vpn *VPN) obfsDecode(obfsed_c <-chan wirePacket, plain_c chan<- []byte) {
 
 	defer func() {
 		log.Warning("Obfusecator decoding worker exited")
@@ -292,10 +378,13 @@ func (vpn *VPN) obfsDecode(obfsed_c <-chan []byte, plain_c chan<- []byte) {
 	buffer := make([]byte, 0, vpn.max_packet_cap)
 OuterLoop:
 	for {
-		data, ok := <-obfsed_c
-		if !ok {
-			break
+		var packet wirePacket
+		select {
+		case <-vpn.stopped:
+			break OuterLoop
+		case packet = <-obfsed_c:
 		}
+		data := packet.data
 		// reverse
 		for i := len(vpn.obfusecators) - 1; i >= 0; i-- {
 			dst := buffer[:cap(buffer)]
@@ -307,19 +396,282 @@ OuterLoop:
 				data, buffer = dst[:declen], data
 			}
 		}
+		if typ, seq, stamp, ok := parseProbe(data); ok {
+			vpn.handleProbe(packet.wire, typ, seq, stamp)
+			continue
+		}
 		plain_c <- data
 	}
 }
 
+// Wait before retrying the wire after error, doubling interval every time.
+// Return false if stopped
+func (vpn *VPN) waitRetry(interval *time.Duration) bool {
+	select {
+	case <-vpn.stopped:
+		return false
+	case <-time.After(*interval):
+	}
+	if *interval *= 2; *interval > VPN_WIRE_MAX_RETRY_INTERVAL {
+		*interval = VPN_WIRE_MAX_RETRY_INTERVAL
+	}
+	return true
+}
+
+// Read packets from wire, tagged with it.
+// Errors are retried, as the wire may recover (e.g. server restarted)
+func (vpn *VPN) readFromWire(w *vpnWire) {
+
+	defer func() {
+		log.WithField("wire", w.trans).Warning("Reading from wire exited")
+		vpn.waiter.Done()
+	}()
+
+	retry_interval := VPN_WIRE_MIN_RETRY_INTERVAL
+	for {
+		buf := make([]byte, vpn.wire_min_mtu, vpn.max_packet_cap)
+		if rdlen, err := w.trans.Read(buf); err != nil {
+			select {
+			case <-vpn.stopped:
+				return
+			default:
+			}
+			log.WithFields(log.Fields{
+				"wire":  w.trans,
+				"error": err,
+				"retry": retry_interval,
+			}).Warning("Error reading from wire, retry later")
+			if !vpn.waitRetry(&retry_interval) {
+				return
+			}
+		} else if rdlen == 0 {
+			log.WithField("wire", w.trans).Warning("Read zero byte from wire, ignore")
+		} else {
+			retry_interval = VPN_WIRE_MIN_RETRY_INTERVAL
+			w.received_once.Do(func() { close(w.received) })
+			// probes keep coming while stopping, do not block
+			select {
+			case <-vpn.stopped:
+				return
+			case vpn.from_wire <- wirePacket{w, buf[:rdlen]}:
+			}
+		}
+	}
+}
+
+// Write probes of the wire, and packets when it's scheduled.
+// Errors are retried like reading
+func (vpn *VPN) writeToWire(w *vpnWire) {
+
+	defer func() {
+		log.WithField("wire", w.trans).Warning("Writing to wire exited")
+		vpn.waiter.Done()
+	}()
+
+	retry_interval := VPN_WIRE_MIN_RETRY_INTERVAL
+	for {
+		changed := vpn.healthChanged()
+		var data_c <-chan []byte
+		if vpn.isScheduled(w) {
+			data_c = vpn.to_wire
+		}
+		var buf []byte
+		select {
+		case <-vpn.stopped:
+			return
+		case <-changed:
+			continue
+		case buf = <-w.probe_c:
+		case data, ok := <-data_c:
+			if !ok {
+				return
+			}
+			buf = data
+		}
+		if wlen, err := w.trans.Write(buf); err != nil {
+			log.WithFields(log.Fields{
+				"wire":  w.trans,
+				"error": err,
+				"retry": retry_interval,
+			}).Warning("Error writing to wire, drop it and retry later")
+			// packets are taken by other wires meanwhile
+			if !vpn.waitRetry(&retry_interval) {
+				return
+			}
+		} else if wlen != len(buf) {
+			log.WithFields(log.Fields{
+				"wire":      w.trans,
+				"buf_len":   len(buf),
+				"write_len": wlen,
+			}).Warning("Not all bytes is wrotten into wire, ignore")
+		} else {
+			retry_interval = VPN_WIRE_MIN_RETRY_INTERVAL
+		}
+	}
+}
+
+// Whether packets should be sent via the wire: all wires not down are used,
+// down wires are used only if all wires are down
+func (vpn *VPN) isScheduled(w *vpnWire) bool {
+	if vpn.options.Health.Disabled || w.health.State() != WIRE_DOWN {
+		return true
+	}
+	for _, other := range vpn.wires {
+		if other.health.State() != WIRE_DOWN {
+			return false
+		}
+	}
+	return true
+}
+
+func (vpn *VPN) healthChanged() <-chan struct{} {
+	vpn.health_lock.Lock()
+	defer vpn.health_lock.Unlock()
+	return vpn.health_changed
+}
+
+// Log and notify writers if state of wire is changed from prev
+func (vpn *VPN) checkHealth(w *vpnWire, prev WireState) {
+	state := w.health.State()
+	if state == prev {
+		return
+	}
+	stats := w.health.Stats()
+	entry := log.WithFields(log.Fields{
+		"wire": w.trans,
+		"from": prev,
+		"to":   state,
+		"loss": stats.Loss,
+		"srtt": stats.SRTT,
+	})
+	if state == WIRE_UP {
+		entry.Info("Wire state changed")
+	} else {
+		entry.Warning("Wire state changed")
+	}
+	vpn.health_lock.Lock()
+	close(vpn.health_changed)
+	vpn.health_changed = make(chan struct{})
+	vpn.health_lock.Unlock()
+}
+
+// Send probe via the wire, dropped if it's busy
+func (vpn *VPN) sendProbe(w *vpnWire, typ byte, seq uint32, stamp int64) {
+	data := make([]byte, PROBE_LENGTH, vpn.max_packet_cap)
+	copy(data, makeProbe(typ, seq, stamp))
+	data, _ = vpn.encode(data, make([]byte, 0, vpn.max_packet_cap))
+	select {
+	case w.probe_c <- data:
+	default:
+		log.WithField("wire", w.trans).Debug("Wire busy, drop probe")
+	}
+}
+
+func (vpn *VPN) handleProbe(w *vpnWire, typ byte, seq uint32, stamp int64) {
+	switch typ {
+	case PROBE_REQUEST:
+		// echo timestamp of peer
+		vpn.sendProbe(w, PROBE_REPLY, seq, stamp)
+	case PROBE_REPLY:
+		if prev, ok := w.health.Reply(seq, stamp, time.Now()); ok {
+			vpn.checkHealth(w, prev)
+		}
+	}
+}
+
+// Send probes periodically, find out lost ones and log stats.
+// Server does not probe until anything is received, as there may be no client
+func (vpn *VPN) probeWire(w *vpnWire) {
+
+	defer vpn.waiter.Done()
+
+	if vpn.is_server {
+		select {
+		case <-vpn.stopped:
+			return
+		case <-w.received:
+		}
+	}
+
+	ticker := time.NewTicker(w.probe_interval)
+	defer ticker.Stop()
+	stats_interval := time.Duration(vpn.options.Health.StatsInterval) * time.Millisecond
+	last_logged := time.Now()
+	for {
+		select {
+		case <-vpn.stopped:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		vpn.checkHealth(w, w.health.Expire(now))
+		vpn.sendProbe(w, PROBE_REQUEST, w.health.Probe(now), now.UnixNano())
+
+		if now.Sub(last_logged) >= stats_interval {
+			last_logged = now
+			stats := w.health.Stats()
+			log.WithFields(log.Fields{
+				"wire":   w.trans,
+				"state":  stats.State,
+				"rtt":    stats.RTT,
+				"srtt":   stats.SRTT,
+				"jitter": stats.Jitter,
+				"loss":   stats.Loss,
+			}).Info("Wire stats")
+		}
+	}
+}
+
+// Stats of each wire, in the order of config.
+// Empty before started
+func (vpn *VPN) Stats() []WireStats {
+	var ret []WireStats
+	for _, w := range vpn.wires {
+		stats := w.health.Stats()
+		stats.Wire = w.trans.String()
+		ret = append(ret, stats)
+	}
+	return ret
+}
+
+// Serve stats of wires as JSON
+func (vpn *VPN) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(vpn.Stats())
+	})
+}
+
 func (vpn *VPN) Start() {
 	vpn.waiter = sync.WaitGroup{}
 
-	vpn.from_wire = make(chan []byte, VPN_CHANNEL_BUFFER)
+	vpn.stopped = make(chan struct{})
+	vpn.health_changed = make(chan struct{})
+	vpn.from_wire = make(chan wirePacket, VPN_CHANNEL_BUFFER)
 	vpn.to_wire = make(chan []byte, VPN_CHANNEL_BUFFER)
-	for _, wire_trans := range vpn.wire_transports {
+	vpn.wires = nil
+	for i, wire_trans := range vpn.wire_transports {
+		health := newWireHealth(&vpn.options.Health)
+		if vpn.is_server && !vpn.options.Health.Disabled {
+			// no client is known until probed, do not prefer it
+			health.state = WIRE_DOWN
+		}
+		vpn.wires = append(vpn.wires, &vpnWire{
+			trans:          wire_trans,
+			health:         health,
+			probe_c:        make(chan []byte, VPN_CHANNEL_BUFFER),
+			probe_interval: vpn.wire_probe_intervals[i],
+			received:       make(chan struct{}),
+		})
+	}
+	for _, w := range vpn.wires {
 		vpn.waiter.Add(2)
-		go vpn.readToChannel(wire_trans, vpn.wire_min_mtu, vpn.from_wire)
-		go vpn.writeFromChannel(wire_trans, vpn.to_wire)
+		go vpn.readFromWire(w)
+		go vpn.writeToWire(w)
+		if !vpn.options.Health.Disabled {
+			vpn.waiter.Add(1)
+			go vpn.probeWire(w)
+		}
 	}
 
 	vpn.from_tun = make(chan []byte, VPN_CHANNEL_BUFFER)
@@ -340,14 +692,16 @@ func (vpn *VPN) Destroy() {
 			close(x)
 		}
 	}
-	close_not_nil(vpn.from_wire)
+	if vpn.stopped != nil {
+		close(vpn.stopped)
+	}
 	close_not_nil(vpn.from_tun)
 	close_not_nil(vpn.to_wire)
 	close_not_nil(vpn.to_tun)
 
 	var err error
 
-	err = tun.ApplyRouter(vpn.wire_rules, vpn.vpn_rules,
+	err = applyRouter(vpn.wire_rules, vpn.vpn_rules,
 		vpn.wire_gw, vpn.vpn_gw, true)
 	log.WithField("error", err).Info("Route rules deleted")
 
@@ -0,0 +1,379 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package justvpn
+
+import "io"
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "bytes"
+import "testing"
+import "sync/atomic"
+import "encoding/json"
+import "net/http/httptest"
+import "github.com/blahgeek/justvpn/tun"
+import "github.com/blahgeek/justvpn/wire"
+
+// TUN device in memory, packets injected are read by VPN,
+// packets written by VPN are captured
+type testTun struct {
+	name     string
+	inject   chan []byte
+	captured chan []byte
+	done     chan struct{}
+	once     sync.Once
+
+	lock sync.Mutex
+	mtu  int
+	ips  map[int]net.IP
+}
+
+func newTestTun() *testTun {
+	return &testTun{
+		inject:   make(chan []byte, 64),
+		captured: make(chan []byte, 64),
+		done:     make(chan struct{}),
+		ips:      make(map[int]net.IP),
+	}
+}
+
+func (t *testTun) Name() string                { return t.name }
+func (t *testTun) Fileno() int                 { return -1 }
+func (t *testTun) GetFlags() (uint16, error)   { return 0, nil }
+func (t *testTun) SetFlags(flags uint16) error { return nil }
+func (t *testTun) Create(name string) error    { t.name = name; return nil }
+func (t *testTun) String() string              { return fmt.Sprintf("[%s]", t.name) }
+
+func (t *testTun) GetMTU() (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.mtu, nil
+}
+
+func (t *testTun) SetMTU(mtu int) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.mtu = mtu
+	return nil
+}
+
+func (t *testTun) GetIPv4(typ int) (net.IP, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.ips[typ], nil
+}
+
+func (t *testTun) SetIPv4(typ int, ip net.IP) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.ips[typ] = ip
+	return nil
+}
+
+func (t *testTun) Read(buf []byte) (int, error) {
+	select {
+	case <-t.done:
+		return 0, io.EOF
+	case data := <-t.inject:
+		return copy(buf, data), nil
+	}
+}
+
+func (t *testTun) Write(buf []byte) (int, error) {
+	packet := make([]byte, len(buf))
+	copy(packet, buf)
+	select {
+	case <-t.done:
+		return 0, io.EOF
+	case t.captured <- packet:
+		return len(buf), nil
+	}
+}
+
+func (t *testTun) Destroy() error {
+	t.once.Do(func() { close(t.done) })
+	return nil
+}
+
+// Replace system dependent operations, return TUN devices created in order
+func testSystem() (chan *testTun, func()) {
+	tuns := make(chan *testTun, 4)
+	new_tun, apply_interface, get_gateway, apply_router := newTun, applyInterfaceRouter, getWireDefaultGateway, applyRouter
+	newTun = func() (tun.Tun, error) {
+		tun := newTestTun()
+		tun.Create("test")
+		tuns <- tun
+		return tun, nil
+	}
+	applyInterfaceRouter = func(tun.Tun) error { return nil }
+	getWireDefaultGateway = func() (net.IP, error) { return net.ParseIP("192.168.1.1"), nil }
+	applyRouter = func(wire_rules, vpn_rules []net.IPNet, wire_gw, vpn_gw net.IP, is_delete bool) error {
+		return nil
+	}
+	return tuns, func() {
+		newTun, applyInterfaceRouter, getWireDefaultGateway, applyRouter = new_tun, apply_interface, get_gateway, apply_router
+	}
+}
+
+func testVPNConfig(name string, wires int) []byte {
+	wire := fmt.Sprintf(`{"name": "loopback", "options": {"name": "%v", "mtu": 1200}}`, name)
+	wire_list := wire
+	for i := 1; i < wires; i += 1 {
+		wire_list += "," + wire
+	}
+	return []byte(fmt.Sprintf(`{
+		"tunnel": {"server": "10.42.0.1", "client": "10.42.0.2"},
+		"wires": [%v],
+		"obfs": [{"name": "xor", "options": {"key": "justvpn"}}],
+		"route": {"vpn": ["8.8.8.8/32"]}
+	}`, wire_list))
+}
+
+func testVPNTransfer(t *testing.T, from, to *testTun, packet []byte) {
+	from.inject <- packet
+	select {
+	case data := <-to.captured:
+		if !bytes.Equal(data, packet) {
+			t.Errorf("Bad packet: %v, expected %v", data, packet)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for packet")
+	}
+}
+
+func TestVPNLoopback(t *testing.T) {
+	tuns, restore := testSystem()
+	defer restore()
+
+	server := &VPN{}
+	if err := server.Init(true, testVPNConfig("TestVPNLoopback", 1)); err != nil {
+		t.Fatalf("Unable to init server: %v", err)
+	}
+	defer server.Destroy()
+	client := &VPN{}
+	if err := client.Init(false, testVPNConfig("TestVPNLoopback", 1)); err != nil {
+		t.Fatalf("Unable to init client: %v", err)
+	}
+	defer client.Destroy()
+	server_tun, client_tun := <-tuns, <-tuns
+
+	// xor does not add overhead
+	if server_tun.mtu != 1200 || client_tun.mtu != 1200 {
+		t.Errorf("Bad TUN MTU: %v %v", server_tun.mtu, client_tun.mtu)
+	}
+	if ip, _ := client_tun.GetIPv4(tun.ADDRESS); !ip.Equal(net.ParseIP("10.42.0.2")) {
+		t.Errorf("Bad client address: %v", ip)
+	}
+	if len(client.vpn_rules) != 1 || client.vpn_rules[0].String() != "8.8.8.8/32" {
+		t.Errorf("Bad VPN routes: %v", client.vpn_rules)
+	}
+
+	server.Start()
+	client.Start()
+
+	testVPNTransfer(t, client_tun, server_tun, []byte("from client"))
+	testVPNTransfer(t, server_tun, client_tun, []byte("from server"))
+	testVPNTransfer(t, client_tun, server_tun, bytes.Repeat([]byte{0x42}, 1200))
+}
+
+func TestVPNLoopbackObfuscated(t *testing.T) {
+	tuns, restore := testSystem()
+	defer restore()
+
+	server := &VPN{}
+	if err := server.Init(true, testVPNConfig("TestVPNLoopbackObfuscated", 1)); err != nil {
+		t.Fatalf("Unable to init server: %v", err)
+	}
+	defer server.Destroy()
+	server_tun := <-tuns
+	server.Start()
+
+	client := &VPN{}
+	if err := client.Init(false, testVPNConfig("TestVPNLoopbackObfuscated", 1)); err != nil {
+		t.Fatalf("Unable to init client: %v", err)
+	}
+	client_tun := <-tuns
+	wire_trans := client.wire_transports[0]
+	client.Start()
+	defer client.Destroy()
+
+	client_tun.inject <- []byte("plain text")
+	select {
+	case data := <-server_tun.captured:
+		if string(data) != "plain text" {
+			t.Errorf("Bad packet: %v", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for packet")
+	}
+	// packets on the wire are obfuscated, raw one is decoded to garbage
+	wire_trans.Write([]byte("plain text"))
+	select {
+	case data := <-server_tun.captured:
+		if string(data) == "plain text" {
+			t.Errorf("Packet should be decoded by xor")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for packet")
+	}
+}
+
+func TestVPNLoopbackDuplicateWire(t *testing.T) {
+	tuns, restore := testSystem()
+	defer restore()
+
+	client := &VPN{}
+	defer client.Destroy()
+	if err := client.Init(false, testVPNConfig("TestVPNLoopbackDuplicateWire", 2)); err == nil {
+		t.Errorf("Two client wires of the same name should fail")
+	}
+	if len(tuns) != 0 {
+		t.Errorf("TUN should not be created if wires fail")
+	}
+}
+
+func TestVPNIsScheduled(t *testing.T) {
+	vpn := &VPN{}
+	vpn.options.Health.setDefaults()
+	for _, state := range []WireState{WIRE_UP, WIRE_DEGRADED, WIRE_DOWN} {
+		w := &vpnWire{health: newWireHealth(&vpn.options.Health)}
+		w.health.state = state
+		vpn.wires = append(vpn.wires, w)
+	}
+	for i, expected := range []bool{true, true, false} {
+		if vpn.isScheduled(vpn.wires[i]) != expected {
+			t.Errorf("Wire %v should be scheduled: %v", vpn.wires[i].health.State(), expected)
+		}
+	}
+	// all down, use them anyway
+	vpn.wires[0].health.state = WIRE_DOWN
+	vpn.wires[1].health.state = WIRE_DOWN
+	for _, w := range vpn.wires {
+		if !vpn.isScheduled(w) {
+			t.Errorf("Wires should be scheduled if all are down")
+		}
+	}
+}
+
+func TestVPNWireFailover(t *testing.T) {
+	tuns, restore := testSystem()
+	defer restore()
+
+	health := `"health": {"interval": 20, "timeout": 50, "down_after": 3}`
+	server := &VPN{}
+	if err := server.Init(true, []byte(`{
+		"tunnel": {"server": "10.42.0.1", "client": "10.42.0.2"},
+		"wires": [{"name": "loopback", "options": {"name": "TestVPNWireFailover-a"}},
+		          {"name": "loopback", "options": {"name": "TestVPNWireFailover-b"}}],
+		`+health+`}`)); err != nil {
+		t.Fatalf("Unable to init server: %v", err)
+	}
+	defer server.Destroy()
+	// wire b of client drops everything
+	client := &VPN{}
+	if err := client.Init(false, []byte(`{
+		"tunnel": {"server": "10.42.0.1", "client": "10.42.0.2"},
+		"wires": [{"name": "loopback", "options": {"name": "TestVPNWireFailover-a"}},
+		          {"name": "impair", "options": {"loss": 1, "direction": "both",
+		              "wire": {"name": "loopback", "options": {"name": "TestVPNWireFailover-b"}}}}],
+		`+health+`}`)); err != nil {
+		t.Fatalf("Unable to init client: %v", err)
+	}
+	defer client.Destroy()
+	server_tun, client_tun := <-tuns, <-tuns
+	server.Start()
+	client.Start()
+
+	for _, vpn := range []*VPN{server, client} {
+		for start := time.Now(); vpn.wires[0].health.State() != WIRE_UP ||
+			vpn.wires[1].health.State() != WIRE_DOWN; {
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("Wire a should be up and b should be down: %v, %v",
+					vpn.wires[0].health.State(), vpn.wires[1].health.State())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// nothing is lost via wire b
+	for i := 0; i < 20; i += 1 {
+		testVPNTransfer(t, client_tun, server_tun, []byte{0x45, byte(i)})
+		testVPNTransfer(t, server_tun, client_tun, []byte{0x45, byte(i)})
+	}
+
+	recorder := httptest.NewRecorder()
+	client.StatsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	var stats []WireStats
+	if err := json.Unmarshal(recorder.Body.Bytes(), &stats); err != nil || len(stats) != 2 {
+		t.Fatalf("Bad stats: %v %v", recorder.Body.String(), err)
+	}
+	if a := stats[0]; a.State != "up" || a.Replied == 0 || a.SRTT <= 0 || a.Loss > 0.5 {
+		t.Errorf("Bad stats of wire a: %+v", a)
+	}
+	if b := stats[1]; b.State != "down" || b.Replied != 0 || b.Loss != 1 || b.Wire != "Impair[Loopback[TestVPNWireFailover-b]]" {
+		t.Errorf("Bad stats of wire b: %+v", b)
+	}
+}
+
+// Wire failing all reads and writes while broken, e.g. connection refused
+type testBrokenWire struct {
+	wire.Transport
+	broken int32
+	errors int32
+}
+
+func (w *testBrokenWire) Read(buf []byte) (int, error) {
+	if atomic.LoadInt32(&w.broken) != 0 {
+		atomic.AddInt32(&w.errors, 1)
+		return 0, fmt.Errorf("Connection refused")
+	}
+	return w.Transport.Read(buf)
+}
+
+func (w *testBrokenWire) Write(buf []byte) (int, error) {
+	if atomic.LoadInt32(&w.broken) != 0 {
+		atomic.AddInt32(&w.errors, 1)
+		return 0, fmt.Errorf("Connection refused")
+	}
+	return w.Transport.Write(buf)
+}
+
+func TestVPNWireRecover(t *testing.T) {
+	tuns, restore := testSystem()
+	defer restore()
+
+	server := &VPN{}
+	if err := server.Init(true, testVPNConfig("TestVPNWireRecover", 1)); err != nil {
+		t.Fatalf("Unable to init server: %v", err)
+	}
+	defer server.Destroy()
+	client := &VPN{}
+	if err := client.Init(false, testVPNConfig("TestVPNWireRecover", 1)); err != nil {
+		t.Fatalf("Unable to init client: %v", err)
+	}
+	defer client.Destroy()
+	server_tun, client_tun := <-tuns, <-tuns
+
+	broken := &testBrokenWire{Transport: client.wire_transports[0], broken: 1}
+	client.wire_transports[0] = broken
+	server.Start()
+	client.Start()
+
+	for start := time.Now(); atomic.LoadInt32(&broken.errors) < 3; {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Wire should be retried")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	atomic.StoreInt32(&broken.broken, 0)
+
+	// the only wire works again
+	testVPNTransfer(t, client_tun, server_tun, []byte("from client"))
+	testVPNTransfer(t, server_tun, client_tun, []byte("from server"))
+}
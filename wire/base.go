@@ -37,8 +37,28 @@ func New(name string, is_server bool, options json.RawMessage) (Transport, error
 	switch name {
 	case "udp":
 		ret = &UDPTransport{}
+	case "tcp":
+		ret = &TCPTransport{}
+	case "tls":
+		ret = &TLSTransport{}
+	case "websocket":
+		ret = &WebSocketTransport{}
+	case "http":
+		ret = &HTTPTransport{}
+	case "icmp":
+		ret = &ICMPTransport{}
+	case "faketcp":
+		ret = &FakeTCPTransport{}
 	case "xmpp":
 		ret = &XMPPTransport{}
+	case "dns":
+		ret = &DNSTransport{}
+	case "pipe":
+		ret = &PipeTransport{}
+	case "loopback":
+		ret = &LoopbackTransport{}
+	case "impair":
+		ret = &ImpairTransport{}
 	default:
 		return ret, fmt.Errorf("No wire transport found: %v", name)
 	}
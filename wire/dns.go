@@ -0,0 +1,135 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-18
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-18
+ */
+
+package wire
+
+import "io"
+import "net"
+import "fmt"
+import "encoding/json"
+import log "github.com/Sirupsen/logrus"
+
+const DNS_DEFAULT_MTU = 1200
+
+// Server side takes DNSTransportServerOptions,
+// client side takes DNSTransportClientOptions, plus "mtu"
+type DNSTransportOptions struct {
+	MTU float64 `json:"mtu"`
+}
+
+type DNSTransport struct {
+	is_server bool
+	server    *DNSTransportServer
+	client    *DNSTransportClient
+	mtu       int
+
+	// packets to send and received packets
+	send_buf chan []byte
+	recv_buf chan []byte
+	done     chan struct{}
+
+	logger *log.Entry
+}
+
+func (trans *DNSTransport) String() string {
+	if trans.is_server {
+		return fmt.Sprintf("DNS[:%v]", trans.server.options.Port)
+	}
+	return fmt.Sprintf("DNS[%v]", trans.client.Resolver())
+}
+
+func (trans *DNSTransport) Open(is_server bool, options json.RawMessage) error {
+	trans.logger = log.WithField("logger", "DNSTransport")
+	trans.is_server = is_server
+	trans.done = make(chan struct{})
+
+	var opt DNSTransportOptions
+	if err := json.Unmarshal(options, &opt); err != nil {
+		return err
+	}
+
+	trans.mtu = DNS_DEFAULT_MTU
+	if opt.MTU > 0 {
+		trans.mtu = int(opt.MTU)
+	}
+
+	var max_mtu int
+	if is_server {
+		trans.server = &DNSTransportServer{}
+		if err := trans.server.Open(options); err != nil {
+			return err
+		}
+		trans.send_buf = trans.server.DownstreamBuf
+		trans.recv_buf = trans.server.UpstreamBuf
+		max_mtu = trans.server.MTU()
+	} else {
+		trans.client = &DNSTransportClient{}
+		if err := trans.client.Open(options); err != nil {
+			return err
+		}
+		trans.send_buf = trans.client.UpstreamBuf
+		trans.recv_buf = trans.client.DownstreamBuf
+		max_mtu = trans.client.MTU()
+	}
+
+	if trans.mtu > max_mtu {
+		trans.logger.WithFields(log.Fields{
+			"mtu": trans.mtu,
+			"max": max_mtu,
+		}).Warning("MTU too large for DNS transport, decrease it")
+		trans.mtu = max_mtu
+	}
+
+	return nil
+}
+
+func (trans *DNSTransport) MTU() int {
+	return trans.mtu
+}
+
+func (trans *DNSTransport) GetWireNetworks() []net.IPNet {
+	if trans.is_server {
+		return make([]net.IPNet, 0)
+	}
+	host, _, _ := net.SplitHostPort(trans.client.Resolver())
+	ip := net.ParseIP(host)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	mask_len := len(ip) * 8
+	return []net.IPNet{
+		net.IPNet{ip, net.CIDRMask(mask_len, mask_len)},
+	}
+}
+
+func (trans *DNSTransport) Close() error {
+	close(trans.done)
+	if trans.is_server {
+		return trans.server.Close()
+	}
+	return trans.client.Close()
+}
+
+func (trans *DNSTransport) Read(buf []byte) (int, error) {
+	select {
+	case <-trans.done:
+		return 0, io.EOF
+	case data := <-trans.recv_buf:
+		return copy(buf, data), nil
+	}
+}
+
+func (trans *DNSTransport) Write(buf []byte) (int, error) {
+	data := make([]byte, len(buf))
+	copy(data, buf)
+	select {
+	case <-trans.done:
+		return 0, io.EOF
+	case trans.send_buf <- data:
+		return len(buf), nil
+	}
+}
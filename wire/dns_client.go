@@ -0,0 +1,383 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-18
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-18
+ */
+
+package wire
+
+import "net"
+import "time"
+import "sync"
+import "net/url"
+import "encoding/json"
+import "github.com/miekg/dns"
+import log "github.com/Sirupsen/logrus"
+
+type DNSTransportClientOptions struct {
+	BaseDomain string `json:"base_domain"`
+	// Address of recursive resolver, e.g. "8.8.8.8:53"
+	Resolver string `json:"resolver"`
+	// Label encoding for upstream, see NewDNSLabelEncoding
+	UpstreamEncoding string `json:"upstream_encoding"`
+	// Min and max interval (in milliseconds) between polling queries,
+	// it backs off to the max while there is no downstream data
+	PollInterval    int `json:"poll_interval"`
+	MaxPollInterval int `json:"max_poll_interval"`
+	// Min and max number of queries in flight
+	MinQueries int `json:"min_queries"`
+	MaxQueries int `json:"max_queries"`
+	// Max queries per second sent to resolver, default to 100, negative means no limit
+	MaxRate float64 `json:"max_rate"`
+	// Time (in milliseconds) before an unanswered query is considered lost
+	QueryTimeout int `json:"query_timeout"`
+	// Talk to resolver via TCP instead of UDP
+	TCP bool `json:"tcp"`
+	// URL of DNS over HTTPS endpoint (e.g. "https://dns.google/dns-query"),
+	// used instead of resolver if set
+	DoH string `json:"doh"`
+	// "POST" (default) or "GET"
+	DoHMethod string `json:"doh_method"`
+
+	DNSReliabilityOptions
+}
+
+const DNSCLIENT_DEFAULT_POLL_INTERVAL = 20
+const DNSCLIENT_DEFAULT_MAX_POLL_INTERVAL = 1000
+const DNSCLIENT_DEFAULT_MIN_QUERIES = 1
+const DNSCLIENT_DEFAULT_MAX_QUERIES = 32
+const DNSCLIENT_DEFAULT_MAX_RATE = 100
+const DNSCLIENT_DEFAULT_QUERY_TIMEOUT = 3000
+const DNSCLIENT_CHANNEL_BUFFER = 64
+const DNSCLIENT_DIAL_TIMEOUT = 5 * time.Second
+const DNSCLIENT_RECONNECT_INTERVAL = time.Second
+
+type DNSTransportClient struct {
+	conn      DNSConn
+	conn_lock sync.Mutex
+	// address of resolver or DoH endpoint to connect to
+	resolver string
+	options  DNSTransportClientOptions
+	logger   *log.Entry
+
+	query_timeout time.Duration
+	controller    *DNSPollController
+
+	// sent time of queries in flight, by ID
+	queries        map[uint16]time.Time
+	queries_lock   sync.Mutex
+	slot_available chan struct{}
+
+	UpstreamBuf   chan []byte
+	DownstreamBuf chan []byte
+
+	endpoint *DNSTransportEndpoint
+	done     chan struct{}
+}
+
+func (trans *DNSTransportClient) Open(options json.RawMessage) error {
+	trans.logger = log.WithField("logger", "DNSTransportClient")
+	if err := json.Unmarshal(options, &trans.options); err != nil {
+		return err
+	}
+	if trans.options.PollInterval <= 0 {
+		trans.options.PollInterval = DNSCLIENT_DEFAULT_POLL_INTERVAL
+	}
+	if trans.options.MaxPollInterval <= 0 {
+		trans.options.MaxPollInterval = DNSCLIENT_DEFAULT_MAX_POLL_INTERVAL
+	}
+	if trans.options.MinQueries <= 0 {
+		trans.options.MinQueries = DNSCLIENT_DEFAULT_MIN_QUERIES
+	}
+	if trans.options.MaxQueries <= 0 {
+		trans.options.MaxQueries = DNSCLIENT_DEFAULT_MAX_QUERIES
+	}
+	if trans.options.MaxRate == 0 {
+		trans.options.MaxRate = DNSCLIENT_DEFAULT_MAX_RATE
+	}
+	if trans.options.QueryTimeout <= 0 {
+		trans.options.QueryTimeout = DNSCLIENT_DEFAULT_QUERY_TIMEOUT
+	}
+	trans.query_timeout = time.Duration(trans.options.QueryTimeout) * time.Millisecond
+	trans.controller = NewDNSPollController(trans.options.MinQueries, trans.options.MaxQueries,
+		time.Duration(trans.options.PollInterval)*time.Millisecond,
+		time.Duration(trans.options.MaxPollInterval)*time.Millisecond,
+		trans.options.MaxRate)
+
+	var err error
+	if trans.resolver, err = trans.resolveServer(); err != nil {
+		return err
+	}
+	upstream_codec, err := NewDNSTransportUpstreamCodec(trans.options.BaseDomain,
+		trans.options.UpstreamEncoding)
+	if err != nil {
+		return err
+	}
+	trans.endpoint = NewDNSTransportEndpoint(upstream_codec,
+		NewDNSTransportDownstreamCodec(), trans.options.DNSReliabilityOptions)
+
+	trans.logger.WithFields(log.Fields{
+		"domain":   trans.options.BaseDomain,
+		"resolver": trans.resolver,
+		"encoding": upstream_codec.encoding,
+		"reliable": trans.options.Reliable,
+		"tcp":      trans.options.TCP,
+	}).Info("Starting new DNS Client")
+
+	if err = trans.dial(); err != nil {
+		return err
+	}
+
+	trans.queries = make(map[uint16]time.Time)
+	trans.slot_available = make(chan struct{}, 1)
+	trans.UpstreamBuf = make(chan []byte)
+	trans.DownstreamBuf = make(chan []byte, DNSCLIENT_CHANNEL_BUFFER)
+	trans.done = make(chan struct{})
+
+	// read dns reply, decode it, put it into channel
+	go func() {
+		for {
+			msg, err := trans.getConn().ReadDNS()
+			if err != nil {
+				select {
+				case <-trans.done:
+					return
+				default:
+				}
+				trans.logger.WithField("error", err).Warn("Error reading DNS reply")
+				if trans.options.TCP {
+					time.Sleep(DNSCLIENT_RECONNECT_INTERVAL)
+					if err = trans.dial(); err != nil {
+						trans.logger.WithField("error", err).Warn("Error reconnecting to resolver")
+					}
+				}
+				continue
+			}
+			sent, ok := trans.finishQuery(msg.Id)
+			if !ok {
+				continue
+			}
+			has_data := false
+			for _, rr := range msg.Answer {
+				txt, ok := rr.(*dns.TXT)
+				if !ok {
+					continue
+				}
+				for _, s := range txt.Txt {
+					decoded_msg, is_data := trans.endpoint.Receive(s)
+					has_data = has_data || is_data
+					if decoded_msg == nil {
+						continue
+					}
+					select {
+					case trans.DownstreamBuf <- decoded_msg:
+					default:
+						trans.logger.Warn("Downstream buffer full, drop packet")
+					}
+				}
+			}
+			trans.controller.OnReply(time.Since(sent), has_data)
+			if trans.options.RetryTimeout <= 0 {
+				if rto := trans.controller.RTO(); rto > 0 {
+					trans.endpoint.SetRetryTimeout(rto)
+				}
+			}
+		}
+	}()
+
+	// read from channel, queue it for sending
+	go func() {
+		for {
+			select {
+			case <-trans.done:
+				return
+			case data := <-trans.UpstreamBuf:
+				trans.endpoint.Push(data)
+			}
+			select {
+			case trans.slot_available <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	// send queued fragments while window and rate limit allows, poll regularly
+	go func() {
+		timer := time.NewTimer(trans.controller.PollInterval())
+		defer timer.Stop()
+		for {
+			poll := false
+			select {
+			case <-trans.done:
+				return
+			case <-trans.slot_available:
+			case <-timer.C:
+				poll = true
+				timer.Reset(trans.controller.PollInterval())
+			}
+			for i := trans.expireQueries(); i > 0; i -= 1 {
+				trans.controller.OnLoss()
+			}
+			for trans.inflight() < trans.controller.Window() && trans.controller.CanSend() {
+				msg := trans.endpoint.Next()
+				if len(msg) == 0 {
+					if !poll {
+						break
+					}
+					msg = trans.endpoint.Control()
+					poll = false
+					trans.controller.OnPoll()
+				}
+				trans.controller.OnSend()
+				trans.query(msg)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (trans *DNSTransportClient) MTU() int {
+	return trans.endpoint.MTU()
+}
+
+// Address of resolver or DoH endpoint
+func (trans *DNSTransportClient) Resolver() string {
+	return trans.resolver
+}
+
+// Resolve resolver (or host of DoH endpoint) into IP address once,
+// so that traffic to it would always bypass the VPN
+func (trans *DNSTransportClient) resolveServer() (string, error) {
+	if len(trans.options.DoH) == 0 {
+		addr, err := net.ResolveUDPAddr("udp", trans.options.Resolver)
+		if err != nil {
+			return "", err
+		}
+		return addr.String(), nil
+	}
+	u, err := url.Parse(trans.options.DoH)
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if len(port) == 0 {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+func (trans *DNSTransportClient) Close() error {
+	close(trans.done)
+	return trans.getConn().Close()
+}
+
+// (Re)connect to resolver
+func (trans *DNSTransportClient) dial() error {
+	if len(trans.options.DoH) > 0 {
+		conn, err := NewDNSHTTPSConn(trans.options.DoH, trans.options.DoHMethod,
+			trans.resolver, trans.options.MaxQueries, trans.query_timeout)
+		if err != nil {
+			return err
+		}
+		trans.conn_lock.Lock()
+		trans.conn = conn
+		trans.conn_lock.Unlock()
+		return nil
+	}
+
+	network := "udp"
+	if trans.options.TCP {
+		network = "tcp"
+	}
+	conn, err := net.DialTimeout(network, trans.resolver, DNSCLIENT_DIAL_TIMEOUT)
+	if err != nil {
+		return err
+	}
+
+	var dns_conn DNSConn = &DNSPacketConn{conn}
+	if trans.options.TCP {
+		dns_conn = &DNSTCPConn{Conn: conn}
+	}
+	trans.conn_lock.Lock()
+	old_conn := trans.conn
+	trans.conn = dns_conn
+	trans.conn_lock.Unlock()
+
+	if old_conn != nil {
+		old_conn.Close()
+	}
+	return nil
+}
+
+func (trans *DNSTransportClient) getConn() DNSConn {
+	trans.conn_lock.Lock()
+	defer trans.conn_lock.Unlock()
+	return trans.conn
+}
+
+func (trans *DNSTransportClient) query(name string) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeTXT)
+
+	trans.queries_lock.Lock()
+	for {
+		if _, exist := trans.queries[m.Id]; !exist {
+			break
+		}
+		m.Id = dns.Id()
+	}
+	trans.queries[m.Id] = time.Now()
+	trans.queries_lock.Unlock()
+
+	if err := trans.getConn().WriteDNS(m); err != nil {
+		trans.logger.WithField("error", err).Warn("Error writing DNS query")
+		trans.finishQuery(m.Id)
+	}
+}
+
+// Remove query from inflight list, return its sent time,
+// or false if it's unknown
+func (trans *DNSTransportClient) finishQuery(id uint16) (time.Time, bool) {
+	trans.queries_lock.Lock()
+	sent, exist := trans.queries[id]
+	delete(trans.queries, id)
+	trans.queries_lock.Unlock()
+
+	if exist {
+		select {
+		case trans.slot_available <- struct{}{}:
+		default:
+		}
+	}
+	return sent, exist
+}
+
+// Remove lost queries, return the number of them
+func (trans *DNSTransportClient) expireQueries() int {
+	deadline := time.Now().Add(-trans.query_timeout)
+	trans.queries_lock.Lock()
+	defer trans.queries_lock.Unlock()
+	count := 0
+	for id, sent := range trans.queries {
+		if sent.Before(deadline) {
+			delete(trans.queries, id)
+			count += 1
+		}
+	}
+	return count
+}
+
+func (trans *DNSTransportClient) inflight() int {
+	trans.queries_lock.Lock()
+	defer trans.queries_lock.Unlock()
+	return len(trans.queries)
+}
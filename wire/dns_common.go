@@ -7,11 +7,15 @@
 
 package wire
 
+import "io"
 import "fmt"
-import "encoding/base32"
+import "sync"
+import "encoding/binary"
+import "strings"
 import "encoding/ascii85"
 import "github.com/miekg/dns"
 import "net"
+import "time"
 import "github.com/blahgeek/justvpn/wire/bitcodec"
 
 const DNS_MAX_UDP_SIZE = 1500
@@ -42,6 +46,89 @@ func (conn *DNSUDPConn) ReadDNSFromUDP() (*dns.Msg, *net.UDPAddr, error) {
 	return m, addr, nil
 }
 
+// Connection to a single DNS peer
+type DNSConn interface {
+	WriteDNS(m *dns.Msg) error
+	ReadDNS() (*dns.Msg, error)
+	Close() error
+	String() string
+}
+
+// Connected UDP socket, one message per datagram
+type DNSPacketConn struct {
+	net.Conn
+}
+
+func (conn *DNSPacketConn) String() string {
+	return conn.RemoteAddr().String()
+}
+
+func (conn *DNSPacketConn) WriteDNS(m *dns.Msg) error {
+	out, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(out)
+	return err
+}
+
+func (conn *DNSPacketConn) ReadDNS() (*dns.Msg, error) {
+	buf := make([]byte, DNS_MAX_UDP_SIZE)
+	rdlen, err := conn.Read(buf)
+	if rdlen == 0 || err != nil {
+		return nil, fmt.Errorf("Error reading from UDP: %v", err)
+	}
+	m := new(dns.Msg)
+	if err = m.Unpack(buf[:rdlen]); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TCP connection, each message is prefixed with two-byte length
+type DNSTCPConn struct {
+	net.Conn
+	write_lock sync.Mutex
+}
+
+func (conn *DNSTCPConn) String() string {
+	return conn.RemoteAddr().String()
+}
+
+func (conn *DNSTCPConn) WriteDNS(m *dns.Msg) error {
+	out, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	if len(out) > 0xffff {
+		return fmt.Errorf("DNS message too large: %v", len(out))
+	}
+	buf := make([]byte, 2+len(out))
+	binary.BigEndian.PutUint16(buf, uint16(len(out)))
+	copy(buf[2:], out)
+
+	conn.write_lock.Lock()
+	defer conn.write_lock.Unlock()
+	_, err = conn.Write(buf)
+	return err
+}
+
+func (conn *DNSTCPConn) ReadDNS() (*dns.Msg, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 type DNSCodecHeader struct {
 	Seq            uint32 `bits:"27"`
 	FragmentNumber uint32 `bits:"4"`
@@ -50,6 +137,7 @@ type DNSCodecHeader struct {
 
 const DNS_FRAGMENT_BIT = 4
 const DNS_MAX_FRAGMENTS = 16
+const DNS_SEQ_MASK = (1 << (32 - 1 - DNS_FRAGMENT_BIT)) - 1
 
 type DNSTransportCodec interface {
 	Encode(msg []byte, header DNSCodecHeader) string
@@ -59,27 +147,37 @@ type DNSTransportCodec interface {
 	GetMaxLength() int
 }
 
-const DNS_UPSTREAM_MAX_LEN_PER_LABEL = 35
+// Header label: encoding tag + header (4 byte --base32--> 7 byte)
+const DNS_UPSTREAM_HEADER_LABEL_LEN = 8
 
 type DNSTransportUpstreamCodec struct {
 	domain             string
 	domain_label_count int
 	max_len_per_name   int
 	header_codec       *bitcodec.Bitcodec
+	encoding           DNSLabelEncoding
 }
 
-func NewDNSTransportUpstreamCodec(domain string) (*DNSTransportUpstreamCodec, error) {
-	ret := DNSTransportUpstreamCodec{domain: domain + "."}
+// Build upstream codec with given label encoding (see NewDNSLabelEncoding),
+// decoding always accepts all encodings
+func NewDNSTransportUpstreamCodec(domain string, encoding string) (*DNSTransportUpstreamCodec, error) {
+	ret := DNSTransportUpstreamCodec{domain: dns.Fqdn(domain)}
 	var ok bool
 	if ret.domain_label_count, ok = dns.IsDomainName(ret.domain); !ok {
-		return nil, fmt.Errorf("Bad domain %d", domain)
+		return nil, fmt.Errorf("Bad domain %v", domain)
 	}
-	name_len := 255 - len(ret.domain)
-	name_len -= 9 // seq number (4 byte --base32--> 8byte) + '.'
-
-	ret.max_len_per_name = name_len / 64 * DNS_UPSTREAM_MAX_LEN_PER_LABEL
-	if tmp := name_len % 64; tmp > 9 {
-		ret.max_len_per_name += (tmp - 1) / 8 * 5
+	var err error
+	if ret.encoding, err = NewDNSLabelEncoding(encoding); err != nil {
+		return nil, err
+	}
+	name_len := 255 - 1 - len(ret.domain)
+	name_len -= DNS_UPSTREAM_HEADER_LABEL_LEN + 1
+
+	// every label costs one more octet for its length
+	ret.max_len_per_name = name_len / (DNS_MAX_LABEL_LEN + 1) *
+		ret.encoding.GetMaxLength(DNS_MAX_LABEL_LEN)
+	if tmp := name_len % (DNS_MAX_LABEL_LEN + 1); tmp > 1 {
+		ret.max_len_per_name += ret.encoding.GetMaxLength(tmp - 1)
 	}
 	ret.header_codec = bitcodec.NewBitcodec(&DNSCodecHeader{})
 
@@ -92,45 +190,68 @@ func (x *DNSTransportUpstreamCodec) GetMaxLength() int {
 
 func (x *DNSTransportUpstreamCodec) Encode(msg []byte, header DNSCodecHeader) string {
 	header_bytes := x.header_codec.EncodeToBytes(&header)
-	ret := base32.StdEncoding.EncodeToString(header_bytes[:])
-	for i := 0; i < len(msg); i += DNS_UPSTREAM_MAX_LEN_PER_LABEL {
-		j := i + DNS_UPSTREAM_MAX_LEN_PER_LABEL
+	ret := string(x.encoding.Tag()) + dns_base32.EncodeToString(header_bytes[:])
+	max_len_per_label := x.encoding.GetMaxLength(DNS_MAX_LABEL_LEN)
+	for i := 0; i < len(msg); i += max_len_per_label {
+		j := i + max_len_per_label
 		if j > len(msg) {
 			j = len(msg)
 		}
-		ret += "." + base32.StdEncoding.EncodeToString(msg[i:j])
+		ret += "." + x.encoding.EncodeLabel(msg[i:j])
 	}
 	ret += "." + x.domain
 	return ret
 }
 
+// Return encoding and header bytes of query name, nil if it's not a tunnel query
+func (x *DNSTransportUpstreamCodec) decodeHeader(labels []string) (DNSLabelEncoding, []byte) {
+	if len(labels) < 1+x.domain_label_count || len(labels[0]) != DNS_UPSTREAM_HEADER_LABEL_LEN {
+		return nil, nil
+	}
+	encoding := getDNSLabelEncodingByTag(labels[0][0])
+	if encoding == nil {
+		return nil, nil
+	}
+	header_bytes, err := dns_base32.DecodeString(strings.ToLower(labels[0][1:]))
+	if err != nil || len(header_bytes) != 4 {
+		return nil, nil
+	}
+	return encoding, header_bytes
+}
+
+// Return whether the name looks like a tunnel query
+func (x *DNSTransportUpstreamCodec) Match(msg string) bool {
+	encoding, _ := x.decodeHeader(dns.SplitDomainName(msg))
+	return encoding != nil
+}
+
 func (x *DNSTransportUpstreamCodec) Decode(msg string) ([]byte, DNSCodecHeader) {
 	var ret []byte
 	var header DNSCodecHeader
 	labels := dns.SplitDomainName(msg)
-	if len(labels) < 1+x.domain_label_count {
-		return nil, header
-	}
-
-	header_bytes, err := base32.StdEncoding.DecodeString(labels[0])
-	if err != nil {
+	encoding, header_bytes := x.decodeHeader(labels)
+	if encoding == nil {
 		return nil, header
 	}
 	x.header_codec.DecodeFromBytes(header_bytes, &header)
 
 	labels = labels[1 : len(labels)-x.domain_label_count]
 	for _, label := range labels {
-		var data []byte
-		data, err = base32.StdEncoding.DecodeString(label)
-		if err == nil {
-			ret = append(ret, data...)
+		data, err := encoding.DecodeLabel(label)
+		if err != nil {
+			return nil, header
 		}
+		ret = append(ret, data...)
 	}
 	return ret, header
 }
 
 const DNS_MAX_TXT_LENGTH = 255
 
+// Quote and backslash are special in TXT strings, replace them with chars unused by ascii85
+var dns_txt_escaper = strings.NewReplacer("\"", "v", "\\", "w")
+var dns_txt_unescaper = strings.NewReplacer("v", "\"", "w", "\\")
+
 type DNSTransportDownstreamCodec struct {
 	header_codec *bitcodec.Bitcodec
 }
@@ -151,15 +272,22 @@ func (x *DNSTransportDownstreamCodec) Encode(msg []byte, header DNSCodecHeader)
 
 	dst := make([]byte, ascii85.MaxEncodedLen(4+len(msg)))
 
-	ret_len := ascii85.Encode(dst[0:5], header_bytes[:])
-	ret_len += ascii85.Encode(dst[5:], msg)
+	// header must take exactly 5 bytes, ascii85 would shorten all zeros to "z"
+	if ascii85.Encode(dst[0:5], header_bytes[:]) != 5 {
+		copy(dst[0:5], "!!!!!")
+	}
+	ret_len := 5 + ascii85.Encode(dst[5:], msg)
 
-	return string(dst[:ret_len])
+	return dns_txt_escaper.Replace(string(dst[:ret_len]))
 }
 
 func (x *DNSTransportDownstreamCodec) Decode(msg string) ([]byte, DNSCodecHeader) {
-	src := []byte(msg)
+	src := []byte(dns_txt_unescaper.Replace(msg))
 	var header DNSCodecHeader
+	if len(src) < 5 {
+		// empty reply
+		return nil, header
+	}
 
 	var header_bytes [4]byte
 	ndst, _, err := ascii85.Decode(header_bytes[:], src[0:5], true)
@@ -168,7 +296,7 @@ func (x *DNSTransportDownstreamCodec) Decode(msg string) ([]byte, DNSCodecHeader
 	}
 	x.header_codec.DecodeFromBytes(header_bytes[:], &header)
 
-	ret := make([]byte, len(msg)/5*4)
+	ret := make([]byte, len(msg)*4)
 	ndst, _, err = ascii85.Decode(ret, src[5:], true)
 	if err != nil {
 		return nil, header
@@ -185,6 +313,7 @@ type DNSTransportStream struct {
 	send_seq    uint32
 	recv_window [DNS_STREAM_WINDOW_SIZE]struct {
 		in_use         bool
+		delivered      bool // kept until the window moves past, to drop retransmissions
 		seq            uint32
 		fragments      [DNS_MAX_FRAGMENTS][]byte
 		fragments_bits uint32
@@ -192,12 +321,23 @@ type DNSTransportStream struct {
 	}
 }
 
-func (x *DNSTransportStream) Encode(msg []byte) []string {
-	var ret []string
+type dnsFragment struct {
+	header  DNSCodecHeader
+	data    []byte
+	sent    time.Time
+	retries int
+}
+
+// Split msg into fragments of at most max_len bytes, with a new seq
+func (x *DNSTransportStream) fragment(msg []byte, max_len int) []*dnsFragment {
+	var ret []*dnsFragment
 	var segment uint32 = 0
-	max_byte_per_seg := x.codec.GetMaxLength()
-	for i := 0; i < len(msg); i += max_byte_per_seg {
-		j := i + max_byte_per_seg
+	// seq 0 is reserved for control messages
+	if x.send_seq&DNS_SEQ_MASK == 0 {
+		x.send_seq += 1
+	}
+	for i := 0; i < len(msg); i += max_len {
+		j := i + max_len
 		if j > len(msg) {
 			j = len(msg)
 		}
@@ -205,17 +345,28 @@ func (x *DNSTransportStream) Encode(msg []byte) []string {
 		if j == len(msg) {
 			has_more_fragment = 0
 		}
-		ret = append(ret, x.codec.Encode(msg[i:j], DNSCodecHeader{
-			Seq:            x.send_seq,
-			FragmentNumber: segment,
-			MoreFragment:   has_more_fragment,
-		}))
+		ret = append(ret, &dnsFragment{
+			header: DNSCodecHeader{
+				Seq:            x.send_seq & DNS_SEQ_MASK,
+				FragmentNumber: segment,
+				MoreFragment:   has_more_fragment,
+			},
+			data: msg[i:j],
+		})
 		segment += 1
 	}
 	x.send_seq += 1
 	return ret
 }
 
+func (x *DNSTransportStream) Encode(msg []byte) []string {
+	var ret []string
+	for _, frag := range x.fragment(msg, x.codec.GetMaxLength()) {
+		ret = append(ret, x.codec.Encode(frag.data, frag.header))
+	}
+	return ret
+}
+
 // return x < y
 func _cmp_seq(x, y uint32) bool {
 	max_div_2 := (((1 << (32 - 1 - DNS_FRAGMENT_BIT)) - 1) >> 1)
@@ -232,18 +383,23 @@ func (x *DNSTransportStream) Decode(msg string) []byte {
 	if dat == nil {
 		return nil
 	}
+	return x.reassemble(dat, header)
+}
 
+func (x *DNSTransportStream) reassemble(dat []byte, header DNSCodecHeader) []byte {
 	box := &x.recv_window[header.Seq%DNS_STREAM_WINDOW_SIZE]
 	if box.in_use && _cmp_seq(header.Seq, box.seq) {
 		// this packet is too late
 		return nil
 	}
-	if box.in_use && header.Seq == box.seq && (box.fragments_bits&(1<<header.FragmentNumber)) != 0 {
+	if box.in_use && header.Seq == box.seq &&
+		(box.delivered || (box.fragments_bits&(1<<header.FragmentNumber)) != 0) {
 		return nil
 	}
 	if !box.in_use || box.seq != header.Seq {
 		// clear this box
 		box.in_use = true
+		box.delivered = false
 		box.seq = header.Seq
 		box.fragments_bits = 0
 		box.fragment_count = 0
@@ -259,8 +415,9 @@ func (x *DNSTransportStream) Decode(msg string) []byte {
 		// all fragments is here
 		for i := 0; i < int(box.fragment_count); i += 1 {
 			ret = append(ret, box.fragments[i]...)
+			box.fragments[i] = nil
 		}
-		box.in_use = false
+		box.delivered = true
 	}
 
 	return ret
@@ -23,28 +23,64 @@ func TestDNSUpstreamEncoding(t *testing.T) {
 		t.Errorf("Bad domain not detected: %v", bad_domain)
 	}
 
-	codec, err := NewDNSTransportUpstreamCodec("blahgeek.com")
-	if err != nil {
-		t.Fatalf("Unable to build codec: %v", err)
+	for _, encoding := range []string{DNS_ENCODING_BASE32, DNS_ENCODING_BASE36, DNS_ENCODING_RAW} {
+		codec, err := NewDNSTransportUpstreamCodec("blahgeek.com", encoding)
+		if err != nil {
+			t.Fatalf("Unable to build codec: %v", err)
+		}
+		streamer := DNSTransportStream{codec: codec}
+
+		for i := 0; i < 1500; i += 1 {
+			var msg []byte
+			for j := 0; j < i; j += 1 {
+				msg = append(msg, byte(rand.Int()&0xff))
+			}
+			var decoded_msg []byte
+			upstreams := streamer.Encode(msg)
+			for _, d := range upstreams {
+				t.Logf("Encoded domain (%v) for msg length %v: %v", encoding, i, d)
+				if _, good := dns.IsDomainName(d); !good {
+					t.Errorf("Bad domain (%v) for msg length %v", encoding, i)
+				}
+				decoded_msg = streamer.Decode(d)
+			}
+			if bytes.Compare(decoded_msg, msg) != 0 {
+				t.Errorf("Decoded msg != msg (%v)", encoding)
+			}
+		}
 	}
-	streamer := DNSTransportStream{codec: codec}
+}
 
-	for i := 0; i < 1500; i += 1 {
-		var msg []byte
-		for j := 0; j < i; j += 1 {
-			msg = append(msg, byte(rand.Int()&0xff))
+func TestDNSUpstreamEncodingCase(t *testing.T) {
+	for _, encoding := range []string{DNS_ENCODING_BASE32, DNS_ENCODING_BASE36} {
+		codec, err := NewDNSTransportUpstreamCodec("blahgeek.com", encoding)
+		if err != nil {
+			t.Fatalf("Unable to build codec: %v", err)
 		}
-		var decoded_msg []byte
-		upstreams := streamer.Encode(msg)
-		for _, d := range upstreams {
-			t.Logf("Encoded domain for msg length %v: %v", i, d)
-			if _, good := dns.IsDomainName(d); !good {
-				t.Errorf("Bad domain for msg length %v", i)
+		streamer := DNSTransportStream{codec: codec}
+
+		for i := 1; i < 1500; i += 7 {
+			msg := make([]byte, i)
+			for j := 0; j < i; j += 1 {
+				msg[j] = byte(rand.Int() & 0xff)
+			}
+			var decoded_msg []byte
+			for _, d := range streamer.Encode(msg) {
+				name := []byte(d)
+				for j, c := range name {
+					if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '.') {
+						t.Fatalf("Not hostname-safe (%v): %v", encoding, d)
+					}
+					// 0x20 randomization
+					if c >= 'a' && c <= 'z' && rand.Int()%2 == 0 {
+						name[j] = c - 'a' + 'A'
+					}
+				}
+				decoded_msg = streamer.Decode(string(name))
+			}
+			if bytes.Compare(decoded_msg, msg) != 0 {
+				t.Errorf("Decoded msg != msg after case randomization (%v)", encoding)
 			}
-			decoded_msg = streamer.Decode(d)
-		}
-		if bytes.Compare(decoded_msg, msg) != 0 {
-			t.Errorf("Decoded msg != msg")
 		}
 	}
 }
@@ -0,0 +1,158 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "sync"
+import "time"
+
+const DNS_POLL_MIN_RTO = 200 * time.Millisecond
+const DNS_POLL_MAX_RTO = 5 * time.Second
+
+// Adapts number of in-flight queries and polling interval of DNS client.
+//
+// The window grows by one for every reply carrying data (the server has
+// backlog), by 1/window for empty replies, and halves on every lost query.
+// Polling interval is reset to minimum when data arrives and doubles
+// on every poll without data coming back since the previous one. A token bucket caps the query rate.
+type DNSPollController struct {
+	min_window, max_window     float64
+	min_interval, max_interval time.Duration
+	max_rate                   float64
+
+	lock     sync.Mutex
+	window   float64
+	interval time.Duration
+	tokens   float64
+	refilled time.Time
+
+	srtt, rttvar time.Duration
+	has_rtt      bool
+	// data came back since last poll
+	has_data bool
+}
+
+func NewDNSPollController(min_window, max_window int,
+	min_interval, max_interval time.Duration, max_rate float64) *DNSPollController {
+	if max_window < min_window {
+		max_window = min_window
+	}
+	if max_interval < min_interval {
+		max_interval = min_interval
+	}
+	return &DNSPollController{
+		min_window:   float64(min_window),
+		max_window:   float64(max_window),
+		min_interval: min_interval,
+		max_interval: max_interval,
+		max_rate:     max_rate,
+		window:       float64(min_window),
+		interval:     min_interval,
+		tokens:       float64(max_window),
+		refilled:     time.Now(),
+	}
+}
+
+// Max number of queries in flight
+func (x *DNSPollController) Window() int {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return int(x.window)
+}
+
+func (x *DNSPollController) PollInterval() time.Duration {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return x.interval
+}
+
+// Retransmission timeout derived from RTT (RFC 6298)
+func (x *DNSPollController) RTO() time.Duration {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if !x.has_rtt {
+		return 0
+	}
+	rto := x.srtt + 4*x.rttvar
+	if rto < DNS_POLL_MIN_RTO {
+		rto = DNS_POLL_MIN_RTO
+	}
+	if rto > DNS_POLL_MAX_RTO {
+		rto = DNS_POLL_MAX_RTO
+	}
+	return rto
+}
+
+// Return true if rate limit allows sending one more query now
+func (x *DNSPollController) CanSend() bool {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if x.max_rate <= 0 {
+		return true
+	}
+	now := time.Now()
+	x.tokens += now.Sub(x.refilled).Seconds() * x.max_rate
+	if x.tokens > x.max_window {
+		x.tokens = x.max_window
+	}
+	x.refilled = now
+	return x.tokens >= 1
+}
+
+func (x *DNSPollController) OnSend() {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.tokens -= 1
+}
+
+func (x *DNSPollController) OnReply(rtt time.Duration, has_data bool) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if !x.has_rtt {
+		x.srtt, x.rttvar, x.has_rtt = rtt, rtt/2, true
+	} else {
+		diff := x.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		x.rttvar = (3*x.rttvar + diff) / 4
+		x.srtt = (7*x.srtt + rtt) / 8
+	}
+
+	if has_data {
+		x.window += 1
+		x.interval = x.min_interval
+		x.has_data = true
+	} else {
+		x.window += 1 / x.window
+	}
+	if x.window > x.max_window {
+		x.window = x.max_window
+	}
+}
+
+func (x *DNSPollController) OnLoss() {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if x.window /= 2; x.window < x.min_window {
+		x.window = x.min_window
+	}
+}
+
+// Called when a poll is sent, backs off if no data came back since last poll
+func (x *DNSPollController) OnPoll() {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if x.has_data {
+		x.has_data = false
+		return
+	}
+	if x.interval *= 2; x.interval > x.max_interval {
+		x.interval = x.max_interval
+	}
+}
@@ -0,0 +1,73 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "testing"
+import "time"
+
+func TestDNSPollController(t *testing.T) {
+	ctrl := NewDNSPollController(1, 16, 10*time.Millisecond, time.Second, 0)
+	if ctrl.Window() != 1 {
+		t.Errorf("Window should start from min: %v", ctrl.Window())
+	}
+	for i := 0; i < 32; i += 1 {
+		ctrl.OnReply(50*time.Millisecond, true)
+	}
+	if ctrl.Window() != 16 {
+		t.Errorf("Window should grow to max with backlog: %v", ctrl.Window())
+	}
+	ctrl.OnLoss()
+	if ctrl.Window() != 8 {
+		t.Errorf("Window should be halved on loss: %v", ctrl.Window())
+	}
+	for i := 0; i < 16; i += 1 {
+		ctrl.OnLoss()
+	}
+	if ctrl.Window() != 1 {
+		t.Errorf("Window should not be less than min: %v", ctrl.Window())
+	}
+
+	for i := 0; i < 16; i += 1 {
+		ctrl.OnPoll()
+	}
+	if ctrl.PollInterval() != time.Second {
+		t.Errorf("Poll interval should back off to max: %v", ctrl.PollInterval())
+	}
+	ctrl.OnReply(50*time.Millisecond, true)
+	if ctrl.PollInterval() != 10*time.Millisecond {
+		t.Errorf("Poll interval should be reset by data: %v", ctrl.PollInterval())
+	}
+	ctrl.OnPoll()
+	if ctrl.PollInterval() != 10*time.Millisecond {
+		t.Errorf("Poll after data should not back off: %v", ctrl.PollInterval())
+	}
+	ctrl.OnPoll()
+	if ctrl.PollInterval() != 20*time.Millisecond {
+		t.Errorf("Empty poll should back off: %v", ctrl.PollInterval())
+	}
+
+	if rto := ctrl.RTO(); rto < DNS_POLL_MIN_RTO || rto > 100*time.Millisecond+DNS_POLL_MIN_RTO {
+		t.Errorf("Bad RTO: %v", rto)
+	}
+}
+
+func TestDNSPollControllerRate(t *testing.T) {
+	ctrl := NewDNSPollController(1, 4, 10*time.Millisecond, time.Second, 100)
+	sent := 0
+	start := time.Now()
+	for time.Since(start) < 200*time.Millisecond {
+		if ctrl.CanSend() {
+			ctrl.OnSend()
+			sent += 1
+		}
+	}
+	// 4 for initial burst, 20 for 200ms
+	if sent > 4+20+1 {
+		t.Errorf("Rate limit exceeded: %v queries sent", sent)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-18
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-18
+ */
+
+package wire
+
+import "fmt"
+import "strings"
+import "math/big"
+import "encoding/base32"
+
+// Encoding of raw bytes into a single label of the query name
+type DNSLabelEncoding interface {
+	// Character identifying this encoding in the header label
+	Tag() byte
+	// Max length of raw bytes that fits in a label of label_len octets
+	GetMaxLength(label_len int) int
+	EncodeLabel(src []byte) string
+	DecodeLabel(label string) ([]byte, error)
+
+	String() string
+}
+
+const DNS_MAX_LABEL_LEN = 63
+
+const (
+	DNS_ENCODING_BASE32 = "base32"
+	DNS_ENCODING_BASE36 = "base36"
+	DNS_ENCODING_RAW    = "raw"
+)
+
+var dns_label_encodings = []DNSLabelEncoding{
+	&DNSBase32Encoding{},
+	&DNSBase36Encoding{},
+	&DNSRawEncoding{},
+}
+
+// Return encoding by name, base32 is used if name is empty
+func NewDNSLabelEncoding(name string) (DNSLabelEncoding, error) {
+	if len(name) == 0 {
+		name = DNS_ENCODING_BASE32
+	}
+	for _, encoding := range dns_label_encodings {
+		if encoding.String() == name {
+			return encoding, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown DNS label encoding: %v", name)
+}
+
+func getDNSLabelEncodingByTag(tag byte) DNSLabelEncoding {
+	if tag >= 'A' && tag <= 'Z' {
+		tag += 'a' - 'A'
+	}
+	for _, encoding := range dns_label_encodings {
+		if encoding.Tag() == tag {
+			return encoding
+		}
+	}
+	return nil
+}
+
+// Lowercase, unpadded base32.
+// Decoding is case-insensitive, so it survives 0x20 randomization of resolvers
+type DNSBase32Encoding struct{}
+
+var dns_base32 = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").
+	WithPadding(base32.NoPadding)
+
+func (x *DNSBase32Encoding) Tag() byte      { return 'a' }
+func (x *DNSBase32Encoding) String() string { return DNS_ENCODING_BASE32 }
+
+func (x *DNSBase32Encoding) GetMaxLength(label_len int) int {
+	return label_len * 5 / 8
+}
+
+func (x *DNSBase32Encoding) EncodeLabel(src []byte) string {
+	return dns_base32.EncodeToString(src)
+}
+
+func (x *DNSBase32Encoding) DecodeLabel(label string) ([]byte, error) {
+	return dns_base32.DecodeString(strings.ToLower(label))
+}
+
+// Base36 ([0-9a-z]), the whole label is treated as a big number.
+// A bit denser than base32 while still hostname-safe and case-insensitive
+type DNSBase36Encoding struct{}
+
+// dns_base36_lens[n] is the length of encoded label for n raw bytes
+var dns_base36_lens [DNS_MAX_LABEL_LEN + 1]int
+
+func init() {
+	for i := range dns_base36_lens {
+		max := new(big.Int).Lsh(big.NewInt(1), uint(i*8))
+		dns_base36_lens[i] = len(max.Sub(max, big.NewInt(1)).Text(36))
+	}
+	dns_base36_lens[0] = 0
+}
+
+func (x *DNSBase36Encoding) Tag() byte      { return 'b' }
+func (x *DNSBase36Encoding) String() string { return DNS_ENCODING_BASE36 }
+
+func (x *DNSBase36Encoding) GetMaxLength(label_len int) int {
+	ret := 0
+	for i, l := range dns_base36_lens {
+		if l <= label_len {
+			ret = i
+		}
+	}
+	return ret
+}
+
+func (x *DNSBase36Encoding) EncodeLabel(src []byte) string {
+	ret := new(big.Int).SetBytes(src).Text(36)
+	if pad := dns_base36_lens[len(src)] - len(ret); pad > 0 {
+		ret = strings.Repeat("0", pad) + ret
+	}
+	return ret
+}
+
+func (x *DNSBase36Encoding) DecodeLabel(label string) ([]byte, error) {
+	for i, l := range dns_base36_lens {
+		if l != len(label) {
+			continue
+		}
+		val, ok := new(big.Int).SetString(strings.ToLower(label), 36)
+		if !ok || val.BitLen() > i*8 {
+			return nil, fmt.Errorf("Bad base36 label: %v", label)
+		}
+		return val.FillBytes(make([]byte, i)), nil
+	}
+	return nil, fmt.Errorf("Bad base36 label length: %v", len(label))
+}
+
+// 8-bit labels, one octet per byte.
+// Only usable when every resolver on the path passes binary labels untouched
+// (no 0x20 randomization, no filtering)
+type DNSRawEncoding struct{}
+
+func (x *DNSRawEncoding) Tag() byte      { return 'c' }
+func (x *DNSRawEncoding) String() string { return DNS_ENCODING_RAW }
+
+func (x *DNSRawEncoding) GetMaxLength(label_len int) int {
+	return label_len
+}
+
+func (x *DNSRawEncoding) EncodeLabel(src []byte) string {
+	ret := make([]byte, 0, len(src)*4)
+	for _, c := range src {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' {
+			ret = append(ret, c)
+		} else {
+			ret = append(ret, fmt.Sprintf("\\%03d", c)...)
+		}
+	}
+	return string(ret)
+}
+
+func (x *DNSRawEncoding) DecodeLabel(label string) ([]byte, error) {
+	ret := make([]byte, 0, len(label))
+	for i := 0; i < len(label); i += 1 {
+		if label[i] != '\\' {
+			ret = append(ret, label[i])
+			continue
+		}
+		if i+3 < len(label) && isDigits(label[i+1:i+4]) {
+			val := int(label[i+1]-'0')*100 + int(label[i+2]-'0')*10 + int(label[i+3]-'0')
+			if val > 0xff {
+				return nil, fmt.Errorf("Bad escape in label: %v", label)
+			}
+			ret = append(ret, byte(val))
+			i += 3
+		} else if i+1 < len(label) {
+			ret = append(ret, label[i+1])
+			i += 1
+		} else {
+			return nil, fmt.Errorf("Bad escape in label: %v", label)
+		}
+	}
+	return ret, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i += 1 {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,250 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-18
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-18
+ */
+
+package wire
+
+import "sync"
+import "time"
+import "math/rand"
+import "encoding/binary"
+import log "github.com/Sirupsen/logrus"
+
+// Options of the optional reliability layer (ARQ),
+// must be the same on both sides
+type DNSReliabilityOptions struct {
+	Reliable bool `json:"reliable"`
+	// Time (in milliseconds) to wait for ACK before retransmitting a fragment
+	RetryTimeout int `json:"retry_timeout"`
+	// Max retransmissions of each fragment before dropping the whole packet
+	MaxRetries int `json:"max_retries"`
+}
+
+const DNS_ARQ_DEFAULT_RETRY_TIMEOUT = 1000
+const DNS_ARQ_DEFAULT_MAX_RETRIES = 5
+const DNS_ARQ_WINDOW = 64
+const DNS_ARQ_MAX_ACKS_PER_FRAGMENT = 4
+const DNS_ARQ_MAX_PENDING_ACKS = 256
+
+const DNS_ENDPOINT_QUEUE_SIZE = 256
+const DNS_CONTROL_NONCE_LEN = 4
+
+// One side of DNS transport, sending with one codec and receiving with another.
+//
+// Fragments with seq 0 are control messages (polls, empty replies),
+// whose payload is [ACK count (1 byte)][ACKs (4 byte each)][nonce].
+// If reliable, data fragments also carry ACKs: [ACK count][ACKs][data],
+// each ACK is seq << DNS_FRAGMENT_BIT | fragment number of a received fragment.
+type DNSTransportEndpoint struct {
+	send    *DNSTransportStream
+	recv    *DNSTransportStream
+	options DNSReliabilityOptions
+
+	retry_timeout time.Duration
+	frag_len      int
+
+	lock     sync.Mutex
+	queue    []*dnsFragment // not sent yet
+	inflight []*dnsFragment // sent but not acked yet, only if reliable
+	acks     []uint32       // ACKs to send
+
+	logger *log.Entry
+}
+
+func NewDNSTransportEndpoint(send_codec, recv_codec DNSTransportCodec,
+	options DNSReliabilityOptions) *DNSTransportEndpoint {
+	x := &DNSTransportEndpoint{
+		send:    &DNSTransportStream{codec: send_codec},
+		recv:    &DNSTransportStream{codec: recv_codec},
+		options: options,
+	}
+	x.logger = log.WithField("logger", "DNSTransportEndpoint")
+	if x.options.RetryTimeout <= 0 {
+		x.options.RetryTimeout = DNS_ARQ_DEFAULT_RETRY_TIMEOUT
+	}
+	if x.options.MaxRetries <= 0 {
+		x.options.MaxRetries = DNS_ARQ_DEFAULT_MAX_RETRIES
+	}
+	x.retry_timeout = time.Duration(x.options.RetryTimeout) * time.Millisecond
+
+	x.frag_len = send_codec.GetMaxLength()
+	if x.options.Reliable {
+		x.frag_len -= 1 + 4*DNS_ARQ_MAX_ACKS_PER_FRAGMENT
+	}
+	return x
+}
+
+// Max length of packet that can be sent
+func (x *DNSTransportEndpoint) MTU() int {
+	return x.frag_len * DNS_MAX_FRAGMENTS
+}
+
+func (x *DNSTransportEndpoint) SetRetryTimeout(timeout time.Duration) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.retry_timeout = timeout
+}
+
+// Number of fragments waiting to be sent
+func (x *DNSTransportEndpoint) Backlog() int {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return len(x.queue)
+}
+
+// Queue a packet for sending, return false if it's dropped
+func (x *DNSTransportEndpoint) Push(msg []byte) bool {
+	if len(msg) > x.MTU() {
+		x.logger.WithField("len", len(msg)).Warning("Packet too large, drop it")
+		return false
+	}
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if len(x.queue) >= DNS_ENDPOINT_QUEUE_SIZE {
+		x.logger.Warning("Send queue full, drop packet")
+		return false
+	}
+	x.queue = append(x.queue, x.send.fragment(msg, x.frag_len)...)
+	return true
+}
+
+// Return next encoded data fragment to send (retransmission first),
+// or empty string if there is none
+func (x *DNSTransportEndpoint) Next() string {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	now := time.Now()
+	for i := 0; i < len(x.inflight); i += 1 {
+		frag := x.inflight[i]
+		if now.Sub(frag.sent) < x.retry_timeout {
+			continue
+		}
+		if frag.retries >= x.options.MaxRetries {
+			x.logger.WithField("seq", frag.header.Seq).
+				Debug("Retry budget exhausted, drop packet")
+			x.dropSeq(frag.header.Seq)
+			i = -1
+			continue
+		}
+		frag.retries += 1
+		frag.sent = now
+		return x.encode(frag)
+	}
+
+	if len(x.queue) == 0 || (x.options.Reliable && len(x.inflight) >= DNS_ARQ_WINDOW) {
+		return ""
+	}
+	frag := x.queue[0]
+	x.queue = x.queue[1:]
+	if x.options.Reliable {
+		frag.sent = now
+		x.inflight = append(x.inflight, frag)
+	}
+	return x.encode(frag)
+}
+
+// Return encoded control message, carrying pending ACKs
+func (x *DNSTransportEndpoint) Control() string {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	max_acks := (x.send.codec.GetMaxLength() - 1 - DNS_CONTROL_NONCE_LEN) / 4
+	if max_acks > 0xff {
+		max_acks = 0xff
+	}
+	payload := x.ackBlock(max_acks)
+	for i := 0; i < DNS_CONTROL_NONCE_LEN; i += 1 {
+		payload = append(payload, byte(rand.Int()&0xff))
+	}
+	return x.send.codec.Encode(payload, DNSCodecHeader{})
+}
+
+// Handle received message, return whole packet if available,
+// and whether the message is a data fragment
+func (x *DNSTransportEndpoint) Receive(msg string) ([]byte, bool) {
+	dat, header := x.recv.codec.Decode(msg)
+	if dat == nil {
+		return nil, false
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if header.Seq == 0 {
+		x.handleAcks(dat)
+		return nil, false
+	}
+	if x.options.Reliable {
+		if dat = x.handleAcks(dat); len(dat) == 0 {
+			return nil, false
+		}
+		// ACK it even if it's a duplicate, previous ACK may be lost,
+		// the stream drops duplicates of delivered packets
+		if len(x.acks) < DNS_ARQ_MAX_PENDING_ACKS {
+			x.acks = append(x.acks, header.Seq<<DNS_FRAGMENT_BIT|header.FragmentNumber)
+		}
+	}
+	return x.recv.reassemble(dat, header), true
+}
+
+// lock must be held
+func (x *DNSTransportEndpoint) encode(frag *dnsFragment) string {
+	if !x.options.Reliable {
+		return x.send.codec.Encode(frag.data, frag.header)
+	}
+	payload := append(x.ackBlock(DNS_ARQ_MAX_ACKS_PER_FRAGMENT), frag.data...)
+	return x.send.codec.Encode(payload, frag.header)
+}
+
+// lock must be held
+func (x *DNSTransportEndpoint) ackBlock(max_acks int) []byte {
+	n := len(x.acks)
+	if n > max_acks {
+		n = max_acks
+	}
+	ret := make([]byte, 1+4*n, 1+4*n+x.frag_len)
+	ret[0] = byte(n)
+	for i := 0; i < n; i += 1 {
+		binary.BigEndian.PutUint32(ret[1+4*i:], x.acks[i])
+	}
+	x.acks = x.acks[n:]
+	return ret
+}
+
+// Remove acked fragments, return remaining data. lock must be held
+func (x *DNSTransportEndpoint) handleAcks(dat []byte) []byte {
+	if len(dat) < 1 || len(dat) < 1+4*int(dat[0]) {
+		return nil
+	}
+	n := int(dat[0])
+	for i := 0; i < n; i += 1 {
+		ack := binary.BigEndian.Uint32(dat[1+4*i:])
+		seq, frag_num := ack>>DNS_FRAGMENT_BIT, ack&(DNS_MAX_FRAGMENTS-1)
+		for j, frag := range x.inflight {
+			if frag.header.Seq == seq && frag.header.FragmentNumber == frag_num {
+				x.inflight = append(x.inflight[:j], x.inflight[j+1:]...)
+				break
+			}
+		}
+	}
+	return dat[1+4*n:]
+}
+
+// Drop all fragments of a packet. lock must be held
+func (x *DNSTransportEndpoint) dropSeq(seq uint32) {
+	filter := func(frags []*dnsFragment) []*dnsFragment {
+		ret := frags[:0]
+		for _, frag := range frags {
+			if frag.header.Seq != seq {
+				ret = append(ret, frag)
+			}
+		}
+		return ret
+	}
+	x.inflight = filter(x.inflight)
+	x.queue = filter(x.queue)
+}
@@ -0,0 +1,107 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-18
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-18
+ */
+
+package wire
+
+import "testing"
+import "time"
+import "math/rand"
+import "encoding/binary"
+
+func TestDNSEndpointReliable(t *testing.T) {
+	upstream_codec, err := NewDNSTransportUpstreamCodec("blahgeek.com", "")
+	if err != nil {
+		t.Fatalf("Unable to build codec: %v", err)
+	}
+	downstream_codec := NewDNSTransportDownstreamCodec()
+	options := DNSReliabilityOptions{
+		Reliable:     true,
+		RetryTimeout: 5,
+		MaxRetries:   50,
+	}
+	client := NewDNSTransportEndpoint(upstream_codec, downstream_codec, options)
+	server := NewDNSTransportEndpoint(downstream_codec, upstream_codec, options)
+
+	const count = 32
+	received := make(map[uint32]bool)
+	for i := 0; i < count; i += 1 {
+		msg := make([]byte, 4+rand.Int()%500)
+		binary.BigEndian.PutUint32(msg, uint32(i))
+		if !client.Push(msg) {
+			t.Fatalf("Unable to push msg %v", i)
+		}
+	}
+
+	// lose 30% of queries and replies
+	lossy := func(msg string) bool {
+		return len(msg) > 0 && rand.Int()%10 >= 3
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(received) < count && time.Now().Before(deadline) {
+		query := client.Next()
+		if len(query) == 0 {
+			query = client.Control()
+			time.Sleep(time.Millisecond)
+		}
+		if !lossy(query) {
+			continue
+		}
+		if msg, _ := server.Receive(query); msg != nil {
+			received[binary.BigEndian.Uint32(msg)] = true
+		}
+		if reply := server.Control(); lossy(reply) {
+			client.Receive(reply)
+		}
+	}
+	if len(received) != count {
+		t.Errorf("Only %v of %v packets received", len(received), count)
+	}
+}
+
+func TestDNSEndpointLostAck(t *testing.T) {
+	upstream_codec, err := NewDNSTransportUpstreamCodec("blahgeek.com", "")
+	if err != nil {
+		t.Fatalf("Unable to build codec: %v", err)
+	}
+	downstream_codec := NewDNSTransportDownstreamCodec()
+	options := DNSReliabilityOptions{
+		Reliable:     true,
+		RetryTimeout: 1,
+		MaxRetries:   50,
+	}
+	client := NewDNSTransportEndpoint(upstream_codec, downstream_codec, options)
+	server := NewDNSTransportEndpoint(downstream_codec, upstream_codec, options)
+
+	client.Push([]byte("hello"))
+	delivered := 0
+	for i := 0; i < 4; i += 1 {
+		query := client.Next()
+		for len(query) == 0 {
+			time.Sleep(2 * time.Millisecond)
+			query = client.Next()
+		}
+		if msg, is_data := server.Receive(query); !is_data {
+			t.Fatalf("Should be data fragment")
+		} else if msg != nil {
+			delivered += 1
+		}
+		if i < 3 {
+			// ACK is lost, so it's retransmitted
+			server.Control()
+		} else {
+			// duplicate is still ACKed
+			client.Receive(server.Control())
+		}
+	}
+	if delivered != 1 {
+		t.Errorf("Packet should be delivered once: %v", delivered)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if query := client.Next(); len(query) != 0 {
+		t.Errorf("Fragment should be acked")
+	}
+}
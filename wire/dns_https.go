@@ -0,0 +1,217 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "io"
+import "fmt"
+import "time"
+import "net"
+import "sync"
+import "bytes"
+import "context"
+import "net/url"
+import "net/http"
+import "strings"
+import "encoding/base64"
+import "github.com/miekg/dns"
+import log "github.com/Sirupsen/logrus"
+
+// DNS over HTTPS (RFC 8484)
+
+const DNS_HTTPS_CONTENT_TYPE = "application/dns-message"
+const DNS_HTTPS_DEFAULT_PATH = "/dns-query"
+const DNS_HTTPS_MAX_MSG_SIZE = 65535
+
+// Client side connection to a DoH endpoint.
+// Each query is a HTTP request, replies are read in the order they arrive
+type DNSHTTPSConn struct {
+	url    *url.URL
+	method string
+	client *http.Client
+
+	replies    chan *dns.Msg
+	done       chan struct{}
+	close_once sync.Once
+
+	logger *log.Entry
+}
+
+// Method is either "POST" or "GET", all connections are made to addr,
+// regardless of the host in url (which is still used for SNI and Host header).
+// Requests not finished within timeout are given up
+func NewDNSHTTPSConn(endpoint, method string, addr string, max_conns int, timeout time.Duration) (*DNSHTTPSConn, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	method = strings.ToUpper(method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	if method != http.MethodPost && method != http.MethodGet {
+		return nil, fmt.Errorf("Bad DoH method: %v", method)
+	}
+
+	dialer := &net.Dialer{Timeout: DNSCLIENT_DIAL_TIMEOUT}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: max_conns,
+	}
+	conn := &DNSHTTPSConn{
+		url:     u,
+		method:  method,
+		client:  &http.Client{Transport: transport, Timeout: timeout},
+		replies: make(chan *dns.Msg, max_conns),
+		done:    make(chan struct{}),
+	}
+	conn.logger = log.WithField("logger", "DNSHTTPSConn")
+	return conn, nil
+}
+
+func (conn *DNSHTTPSConn) String() string {
+	return conn.url.String()
+}
+
+func (conn *DNSHTTPSConn) WriteDNS(m *dns.Msg) error {
+	// ID should be 0 for cache friendliness, each reply comes in its own response anyway
+	req_msg := m.Copy()
+	req_msg.Id = 0
+	out, err := req_msg.Pack()
+	if err != nil {
+		return err
+	}
+
+	var req *http.Request
+	if conn.method == http.MethodGet {
+		u := *conn.url
+		query := u.Query()
+		query.Set("dns", base64.RawURLEncoding.EncodeToString(out))
+		u.RawQuery = query.Encode()
+		req, err = http.NewRequest(http.MethodGet, u.String(), nil)
+	} else {
+		req, err = http.NewRequest(http.MethodPost, conn.url.String(), bytes.NewReader(out))
+		if err == nil {
+			req.Header.Set("Content-Type", DNS_HTTPS_CONTENT_TYPE)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", DNS_HTTPS_CONTENT_TYPE)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-conn.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		defer cancel()
+		reply, err := conn.roundTrip(req.WithContext(ctx))
+		if err != nil {
+			select {
+			case <-conn.done:
+			default:
+				conn.logger.WithField("error", err).Debug("Error sending DoH query")
+			}
+			return
+		}
+		reply.Id = m.Id
+		select {
+		case conn.replies <- reply:
+		case <-conn.done:
+		}
+	}()
+	return nil
+}
+
+func (conn *DNSHTTPSConn) roundTrip(req *http.Request) (*dns.Msg, error) {
+	resp, err := conn.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Bad DoH response status: %v", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, DNS_HTTPS_MAX_MSG_SIZE))
+	if err != nil {
+		return nil, err
+	}
+	m := new(dns.Msg)
+	if err = m.Unpack(body); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (conn *DNSHTTPSConn) ReadDNS() (*dns.Msg, error) {
+	select {
+	case <-conn.done:
+		return nil, io.EOF
+	case m := <-conn.replies:
+		return m, nil
+	}
+}
+
+func (conn *DNSHTTPSConn) Close() error {
+	conn.close_once.Do(func() {
+		close(conn.done)
+	})
+	conn.client.CloseIdleConnections()
+	return nil
+}
+
+// Server side reply writer of one DoH request
+type dnsHTTPReplyWriter struct {
+	client string
+	reply  chan *dns.Msg
+}
+
+func (w *dnsHTTPReplyWriter) WriteDNS(m *dns.Msg) error {
+	select {
+	case w.reply <- m:
+		return nil
+	default:
+		return fmt.Errorf("DoH request already answered")
+	}
+}
+
+func (w *dnsHTTPReplyWriter) String() string {
+	return w.client
+}
+
+// Read DNS query from DoH request
+func readDNSFromHTTP(r *http.Request) (*dns.Msg, error) {
+	var out []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		out, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+	case http.MethodPost:
+		if content_type := r.Header.Get("Content-Type"); content_type != DNS_HTTPS_CONTENT_TYPE {
+			return nil, fmt.Errorf("Bad content type: %v", content_type)
+		}
+		out, err = io.ReadAll(io.LimitReader(r.Body, DNS_HTTPS_MAX_MSG_SIZE))
+	default:
+		return nil, fmt.Errorf("Bad method: %v", r.Method)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := new(dns.Msg)
+	if err = m.Unpack(out); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -9,6 +9,8 @@ package wire
 
 import "net"
 import "time"
+import "sync"
+import "net/http"
 import "encoding/json"
 import "github.com/miekg/dns"
 import log "github.com/Sirupsen/logrus"
@@ -16,28 +18,88 @@ import log "github.com/Sirupsen/logrus"
 type DNSTransportServerOptions struct {
 	BaseDomain string `json:"base_domain"`
 	Port       int    `json:"port"`
+	// Max time (in milliseconds) to hold a query before answering it empty
+	HoldTime int `json:"hold_time"`
+	// Max number of held queries for each client (resolver address)
+	MaxQueriesPerClient int `json:"max_queries_per_client"`
+	// Resolver to forward queries outside base domain to, refuse them if empty (default).
+	// Anyone reaching the port can use it as a recursive resolver then
+	Forward string `json:"forward"`
+	// Max number of queries being forwarded, more are answered with SERVFAIL
+	MaxForwarding int `json:"max_forwarding"`
+	// Also listen on TCP
+	TCP bool `json:"tcp"`
+	// Also accept DNS over HTTPS on this address (e.g. ":443"),
+	// use plain HTTP if certificate is not set (e.g. behind reverse proxy)
+	DoH     string `json:"doh"`
+	DoHPath string `json:"doh_path"`
+	DoHCert string `json:"doh_cert"`
+	DoHKey  string `json:"doh_key"`
+
+	DNSZoneOptions
+	DNSReliabilityOptions
+}
+
+const DNSSERVER_DEFAULT_HOLD_TIME = 500
+const DNSSERVER_DEFAULT_MAX_QUERIES_PER_CLIENT = 64
+const DNSSERVER_CHANNEL_BUFFER = 64
+const DNSSERVER_RETRY_CHECK_INTERVAL = 100 * time.Millisecond
+const DNSSERVER_FORWARD_TIMEOUT = 2 * time.Second
+const DNSSERVER_DEFAULT_MAX_FORWARDING = 16
+const DNSSERVER_TCP_IDLE_TIMEOUT = 2 * time.Minute
+const DNSSERVER_TCP_MAX_FRAGMENTS_PER_REPLY = 16
+
+// Where to send reply of a query, its String() identifies the client
+type DNSReplyWriter interface {
+	WriteDNS(m *dns.Msg) error
+	String() string
+}
+
+type dnsUDPReplyWriter struct {
+	conn *DNSUDPConn
+	addr *net.UDPAddr
+}
+
+func (w *dnsUDPReplyWriter) WriteDNS(m *dns.Msg) error {
+	return w.conn.WriteDNSToUDP(m, w.addr)
 }
 
-const DNSSERVER_QUERY_BUFSIZE = 10240
-const DNSSERVER_QUERY_TIMEOUT = 3 * time.Second
+// identify client by IP only, resolvers randomize source ports
+func (w *dnsUDPReplyWriter) String() string {
+	return w.addr.IP.String()
+}
 
 type DNSServerQuery struct {
-	Msg  *dns.Msg
-	Addr *net.UDPAddr
-	Time time.Time
+	Msg    *dns.Msg
+	Writer DNSReplyWriter
+	Time   time.Time
 }
 
 type DNSTransportServer struct {
-	conn    *DNSUDPConn
-	options DNSTransportServerOptions
-	logger  *log.Entry
+	conn     *DNSUDPConn
+	listener *net.TCPListener
+	http     *http.Server
+	options  DNSTransportServerOptions
+	logger   *log.Entry
+
+	hold_time time.Duration
+
+	// held queries, oldest first
+	queries           []DNSServerQuery
+	queries_count     map[string]int
+	queries_lock      sync.Mutex
+	queries_available chan struct{}
+	data_available    chan struct{}
+	// one token for each query being forwarded
+	forwarding chan struct{}
 
-	queries       chan DNSServerQuery
 	UpstreamBuf   chan []byte
 	DownstreamBuf chan []byte
 
-	upstream_codec   *DNSTransportStream
-	downstream_codec *DNSTransportStream
+	upstream_codec *DNSTransportUpstreamCodec
+	endpoint       *DNSTransportEndpoint
+	zone           *DNSZone
+	done           chan struct{}
 }
 
 func (trans *DNSTransportServer) Open(options json.RawMessage) error {
@@ -48,11 +110,25 @@ func (trans *DNSTransportServer) Open(options json.RawMessage) error {
 		if trans.options.Port == 0 {
 			trans.options.Port = 53
 		}
+		if trans.options.HoldTime <= 0 {
+			trans.options.HoldTime = DNSSERVER_DEFAULT_HOLD_TIME
+		}
+		if trans.options.MaxQueriesPerClient <= 0 {
+			trans.options.MaxQueriesPerClient = DNSSERVER_DEFAULT_MAX_QUERIES_PER_CLIENT
+		}
+		if trans.options.MaxForwarding <= 0 {
+			trans.options.MaxForwarding = DNSSERVER_DEFAULT_MAX_FORWARDING
+		}
 		trans.logger.WithFields(log.Fields{
-			"domain": trans.options.BaseDomain,
-			"port":   trans.options.Port,
+			"domain":    trans.options.BaseDomain,
+			"port":      trans.options.Port,
+			"hold_time": trans.options.HoldTime,
+			"reliable":  trans.options.Reliable,
+			"tcp":       trans.options.TCP,
+			"doh":       trans.options.DoH,
 		}).Info("Starting new DNS Server")
 	}
+	trans.hold_time = time.Duration(trans.options.HoldTime) * time.Millisecond
 
 	if udp_conn, err := net.ListenUDP("udp", &net.UDPAddr{
 		IP:   net.IPv4(0, 0, 0, 0),
@@ -63,72 +139,404 @@ func (trans *DNSTransportServer) Open(options json.RawMessage) error {
 		trans.conn = &DNSUDPConn{udp_conn}
 	}
 
-	trans.queries = make(chan DNSServerQuery, DNSSERVER_QUERY_BUFSIZE)
-	trans.UpstreamBuf = make(chan []byte)
+	trans.queries_count = make(map[string]int)
+	trans.queries_available = make(chan struct{}, 1)
+	trans.data_available = make(chan struct{}, 1)
+	trans.forwarding = make(chan struct{}, trans.options.MaxForwarding)
+	trans.UpstreamBuf = make(chan []byte, DNSSERVER_CHANNEL_BUFFER)
 	trans.DownstreamBuf = make(chan []byte)
+	trans.done = make(chan struct{})
 
 	var err error
-	trans.upstream_codec = &DNSTransportStream{}
-	if trans.upstream_codec.codec, err = NewDNSTransportUpstreamCodec(trans.options.BaseDomain); err != nil {
+	if trans.upstream_codec, err = NewDNSTransportUpstreamCodec(trans.options.BaseDomain, ""); err != nil {
+		trans.conn.Close()
+		return err
+	}
+	trans.endpoint = NewDNSTransportEndpoint(NewDNSTransportDownstreamCodec(),
+		trans.upstream_codec, trans.options.DNSReliabilityOptions)
+	if trans.zone, err = NewDNSZone(trans.options.BaseDomain, trans.options.DNSZoneOptions); err != nil {
+		trans.conn.Close()
 		return err
 	}
-	trans.downstream_codec = &DNSTransportStream{codec: NewDNSTransportDownstreamCodec()}
+
+	if trans.options.TCP {
+		if trans.listener, err = net.ListenTCP("tcp", &net.TCPAddr{
+			IP:   net.IPv4(0, 0, 0, 0),
+			Port: trans.options.Port,
+		}); err != nil {
+			trans.conn.Close()
+			return err
+		}
+		go trans.acceptTCP()
+	}
+
+	if len(trans.options.DoH) > 0 {
+		if err = trans.serveHTTP(); err != nil {
+			if trans.listener != nil {
+				trans.listener.Close()
+			}
+			trans.conn.Close()
+			return err
+		}
+	}
 
 	// read dns query, decode it, put it into channel
 	go func() {
 		for {
 			msg, addr, err := trans.conn.ReadDNSFromUDP()
 			if err != nil {
+				select {
+				case <-trans.done:
+					return
+				default:
+				}
 				trans.logger.WithField("error", err).Warn("Error reading DNS query")
 				continue
-			} else {
-				if msg.Response || len(msg.Question) == 0 || msg.Question[0].Qtype != dns.TypeTXT {
-					trans.logger.WithField("msg", msg).Warn("Unknown DNS query")
-					continue
-				}
-				trans.queries <- DNSServerQuery{
-					Msg:  msg,
-					Addr: addr,
-					Time: time.Now(),
-				}
-				decoded_msg := trans.upstream_codec.Decode(msg.Question[0].Name)
-				if decoded_msg != nil {
-					trans.UpstreamBuf <- decoded_msg
-				}
 			}
+			trans.handleQuery(msg, &dnsUDPReplyWriter{trans.conn, addr})
 		}
 	}()
 
-	// read from channel, encode it, send it
+	// answer queries that are held for too long with empty (or ACK) reply
 	go func() {
+		ticker := time.NewTicker(trans.hold_time / 4)
+		defer ticker.Stop()
 		for {
-			data := <-trans.DownstreamBuf
-			encoded_msgs := trans.downstream_codec.Encode(data)
-
-			for _, msg := range encoded_msgs {
-				now := time.Now()
-				var query DNSServerQuery
-				for {
-					query = <-trans.queries
-					if query.Time.Add(DNSSERVER_QUERY_TIMEOUT).After(now) {
-						break
+			select {
+			case <-trans.done:
+				return
+			case <-ticker.C:
+			}
+			for _, query := range trans.popExpiredQueries() {
+				trans.reply(query, trans.endpoint.Control())
+			}
+		}
+	}()
+
+	// read from channel, queue it for sending
+	go func() {
+		for {
+			select {
+			case <-trans.done:
+				return
+			case data := <-trans.DownstreamBuf:
+				trans.endpoint.Push(data)
+			}
+			select {
+			case trans.data_available <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	// answer held queries with queued (or retransmitted) fragments
+	go func() {
+		ticker := time.NewTicker(DNSSERVER_RETRY_CHECK_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-trans.done:
+				return
+			case <-trans.queries_available:
+			case <-trans.data_available:
+			case <-ticker.C:
+			}
+			for {
+				query, ok := trans.popQuery()
+				if !ok {
+					break
+				}
+				msg := trans.endpoint.Next()
+				if len(msg) == 0 {
+					trans.unpopQuery(query)
+					break
+				}
+				msgs := []string{msg}
+				// TCP and HTTP are not limited by UDP packet size, send more at once
+				if isStreamWriter(query.Writer) {
+					for len(msgs) < DNSSERVER_TCP_MAX_FRAGMENTS_PER_REPLY {
+						if msg = trans.endpoint.Next(); len(msg) == 0 {
+							break
+						}
+						msgs = append(msgs, msg)
 					}
 				}
-				txt := new(dns.TXT)
-				txt.Hdr = dns.RR_Header{Name: query.Msg.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0}
-				txt.Txt = []string{msg}
-				reply := new(dns.Msg)
-				reply.SetReply(query.Msg)
-				reply.Answer = append(reply.Answer, txt)
+				trans.reply(query, msgs...)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (trans *DNSTransportServer) MTU() int {
+	return trans.endpoint.MTU()
+}
 
-				err := trans.conn.WriteDNSToUDP(reply, query.Addr)
+func (trans *DNSTransportServer) Close() error {
+	close(trans.done)
+	if trans.listener != nil {
+		trans.listener.Close()
+	}
+	if trans.http != nil {
+		trans.http.Close()
+	}
+	return trans.conn.Close()
+}
+
+func (trans *DNSTransportServer) acceptTCP() {
+	for {
+		conn, err := trans.listener.AcceptTCP()
+		if err != nil {
+			select {
+			case <-trans.done:
+				return
+			default:
+			}
+			trans.logger.WithField("error", err).Warn("Error accepting TCP connection")
+			time.Sleep(time.Second)
+			continue
+		}
+		trans.logger.WithField("remote", conn.RemoteAddr()).Debug("New TCP connection")
+		go func() {
+			dns_conn := &DNSTCPConn{Conn: conn}
+			defer dns_conn.Close()
+			for {
+				dns_conn.SetReadDeadline(time.Now().Add(DNSSERVER_TCP_IDLE_TIMEOUT))
+				msg, err := dns_conn.ReadDNS()
 				if err != nil {
-					trans.logger.WithField("error", err).Warn("Error writing to UDP")
-					continue
+					trans.logger.WithFields(log.Fields{
+						"remote": dns_conn,
+						"error":  err,
+					}).Debug("TCP connection closed")
+					return
 				}
+				trans.handleQuery(msg, dns_conn)
 			}
+		}()
+	}
+}
+
+func (trans *DNSTransportServer) serveHTTP() error {
+	path := trans.options.DoHPath
+	if len(path) == 0 {
+		path = DNS_HTTPS_DEFAULT_PATH
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, trans)
+	trans.http = &http.Server{Handler: mux}
+
+	listener, err := net.Listen("tcp", trans.options.DoH)
+	if err != nil {
+		return err
+	}
+	go func() {
+		var err error
+		if len(trans.options.DoHCert) > 0 {
+			err = trans.http.ServeTLS(listener, trans.options.DoHCert, trans.options.DoHKey)
+		} else {
+			err = trans.http.Serve(listener)
+		}
+		select {
+		case <-trans.done:
+		default:
+			trans.logger.WithField("error", err).Error("DoH server stopped")
 		}
 	}()
-
 	return nil
 }
+
+// Handle DoH request, wait until the query is answered
+func (trans *DNSTransportServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	msg, err := readDNSFromHTTP(r)
+	if err != nil {
+		trans.logger.WithField("error", err).Debug("Bad DoH request")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// identify client by address only, it may use many connections
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client = host
+	}
+	writer := &dnsHTTPReplyWriter{client: client, reply: make(chan *dns.Msg, 1)}
+	trans.handleQuery(msg, writer)
+
+	select {
+	case <-trans.done:
+		http.Error(w, "Server closed", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	case reply := <-writer.reply:
+		out, err := reply.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", DNS_HTTPS_CONTENT_TYPE)
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Write(out)
+	}
+}
+
+func (trans *DNSTransportServer) handleQuery(msg *dns.Msg, writer DNSReplyWriter) {
+	if msg.Response {
+		trans.logger.WithField("msg", msg).Warn("Unknown DNS query")
+		return
+	}
+	if len(msg.Question) == 0 || !trans.zone.Contains(msg.Question[0].Name) {
+		if len(trans.options.Forward) > 0 && len(msg.Question) > 0 {
+			select {
+			case trans.forwarding <- struct{}{}:
+				go trans.forward(msg, writer)
+			default:
+				trans.logger.WithField("question", msg.Question[0]).Debug("Too many queries forwarding")
+				reply := new(dns.Msg)
+				reply.SetRcode(msg, dns.RcodeServerFailure)
+				trans.write(reply, writer)
+			}
+		} else {
+			trans.write(trans.zone.Answer(msg), writer)
+		}
+		return
+	}
+	if msg.Question[0].Qtype != dns.TypeTXT || !trans.upstream_codec.Match(msg.Question[0].Name) {
+		trans.logger.WithField("question", msg.Question[0]).Debug("Answering non-tunnel query")
+		trans.write(trans.zone.Answer(msg), writer)
+		return
+	}
+	trans.pushQuery(DNSServerQuery{
+		Msg:    msg,
+		Writer: writer,
+		Time:   time.Now(),
+	})
+	decoded_msg, _ := trans.endpoint.Receive(msg.Question[0].Name)
+	if decoded_msg != nil {
+		select {
+		case trans.UpstreamBuf <- decoded_msg:
+		default:
+			trans.logger.Warn("Upstream buffer full, drop packet")
+		}
+	}
+}
+
+// Hold a query until there's data for it or it expires,
+// if the client already has too many queries held, answer its oldest one now
+func (trans *DNSTransportServer) pushQuery(query DNSServerQuery) {
+	client := query.Writer.String()
+	var dropped []DNSServerQuery
+
+	trans.queries_lock.Lock()
+	for i := 0; trans.queries_count[client] >= trans.options.MaxQueriesPerClient && i < len(trans.queries); {
+		if trans.queries[i].Writer.String() == client {
+			dropped = append(dropped, trans.queries[i])
+			trans.queries = append(trans.queries[:i], trans.queries[i+1:]...)
+			trans.queries_count[client] -= 1
+		} else {
+			i += 1
+		}
+	}
+	trans.queries = append(trans.queries, query)
+	trans.queries_count[client] += 1
+	trans.queries_lock.Unlock()
+
+	select {
+	case trans.queries_available <- struct{}{}:
+	default:
+	}
+	for _, q := range dropped {
+		trans.reply(q, trans.endpoint.Control())
+	}
+}
+
+// Pop oldest query, return false if there is none
+func (trans *DNSTransportServer) popQuery() (DNSServerQuery, bool) {
+	trans.queries_lock.Lock()
+	defer trans.queries_lock.Unlock()
+	if len(trans.queries) == 0 {
+		return DNSServerQuery{}, false
+	}
+	query := trans.queries[0]
+	trans.queries = trans.queries[1:]
+	trans.decQueryCount(query)
+	return query, true
+}
+
+// Put back a query returned by popQuery
+func (trans *DNSTransportServer) unpopQuery(query DNSServerQuery) {
+	trans.queries_lock.Lock()
+	defer trans.queries_lock.Unlock()
+	trans.queries = append([]DNSServerQuery{query}, trans.queries...)
+	trans.queries_count[query.Writer.String()] += 1
+}
+
+func (trans *DNSTransportServer) popExpiredQueries() []DNSServerQuery {
+	var ret []DNSServerQuery
+	deadline := time.Now().Add(-trans.hold_time)
+
+	trans.queries_lock.Lock()
+	defer trans.queries_lock.Unlock()
+	for len(trans.queries) > 0 && trans.queries[0].Time.Before(deadline) {
+		ret = append(ret, trans.queries[0])
+		trans.decQueryCount(trans.queries[0])
+		trans.queries = trans.queries[1:]
+	}
+	return ret
+}
+
+// queries_lock must be held
+func (trans *DNSTransportServer) decQueryCount(query DNSServerQuery) {
+	client := query.Writer.String()
+	if trans.queries_count[client] -= 1; trans.queries_count[client] <= 0 {
+		delete(trans.queries_count, client)
+	}
+}
+
+func (trans *DNSTransportServer) reply(query DNSServerQuery, msgs ...string) {
+	txt := new(dns.TXT)
+	txt.Hdr = dns.RR_Header{Name: query.Msg.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0}
+	txt.Txt = msgs
+	reply := new(dns.Msg)
+	reply.SetReply(query.Msg)
+	reply.Authoritative = true
+	reply.Answer = append(reply.Answer, txt)
+
+	trans.write(reply, query.Writer)
+}
+
+// Whether replies to the writer are not limited by UDP packet size
+func isStreamWriter(writer DNSReplyWriter) bool {
+	switch writer.(type) {
+	case *DNSTCPConn, *dnsHTTPReplyWriter:
+		return true
+	}
+	return false
+}
+
+func (trans *DNSTransportServer) write(m *dns.Msg, writer DNSReplyWriter) {
+	if err := writer.WriteDNS(m); err != nil {
+		trans.logger.WithFields(log.Fields{
+			"client": writer,
+			"error":  err,
+		}).Warn("Error writing DNS reply")
+	}
+}
+
+// Forward query to upstream resolver, relay its reply
+func (trans *DNSTransportServer) forward(req *dns.Msg, writer DNSReplyWriter) {
+	defer func() { <-trans.forwarding }()
+	client := dns.Client{Timeout: DNSSERVER_FORWARD_TIMEOUT}
+	if isStreamWriter(writer) {
+		client.Net = "tcp"
+	}
+	reply, _, err := client.Exchange(req, trans.options.Forward)
+	if err != nil {
+		trans.logger.WithFields(log.Fields{
+			"question": req.Question[0],
+			"error":    err,
+		}).Debug("Error forwarding query")
+		reply = new(dns.Msg)
+		reply.SetRcode(req, dns.RcodeServerFailure)
+	}
+	reply.Id = req.Id
+	trans.write(reply, writer)
+}
@@ -0,0 +1,176 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-18
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-18
+ */
+
+package wire
+
+import "testing"
+import "time"
+import "net"
+import "bytes"
+import "encoding/json"
+import "github.com/miekg/dns"
+
+func openTestDNSServer(t *testing.T, options DNSTransportServerOptions) *DNSTransportServer {
+	options_str, err := json.Marshal(options)
+	if err != nil {
+		t.Fatalf("Unable to build option: %v", err)
+	}
+	server := &DNSTransportServer{}
+	if err = server.Open(json.RawMessage(options_str)); err != nil {
+		t.Fatalf("Unable to open server: %v", err)
+	}
+	return server
+}
+
+func exchangeTestDNS(t *testing.T, name string, port string) (string, time.Duration) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeTXT)
+	client := dns.Client{Timeout: 3 * time.Second}
+	reply, rtt, err := client.Exchange(m, "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatalf("Error exchanging DNS query: %v", err)
+	}
+	if len(reply.Answer) != 1 {
+		t.Fatalf("Bad answer: %v", reply)
+	}
+	txt, ok := reply.Answer[0].(*dns.TXT)
+	if !ok {
+		t.Fatalf("No TXT answer: %v", reply)
+	}
+	return txt.Txt[0], rtt
+}
+
+func TestDNSServerEmptyReply(t *testing.T) {
+	server := openTestDNSServer(t, DNSTransportServerOptions{
+		BaseDomain: "blahgeek.com",
+		Port:       53541,
+		HoldTime:   100,
+	})
+	defer server.Close()
+	codec, _ := NewDNSTransportUpstreamCodec("blahgeek.com", "")
+	name := codec.Encode(nil, DNSCodecHeader{Seq: 1})
+
+	txt, rtt := exchangeTestDNS(t, name, "53541")
+	endpoint := NewDNSTransportEndpoint(codec, NewDNSTransportDownstreamCodec(),
+		DNSReliabilityOptions{})
+	if decoded, _ := endpoint.Receive(txt); decoded != nil {
+		t.Errorf("Reply should be empty: %v", decoded)
+	}
+	if rtt > time.Second {
+		t.Errorf("Query held for too long: %v", rtt)
+	}
+}
+
+func TestDNSServerEcho(t *testing.T) {
+	server := openTestDNSServer(t, DNSTransportServerOptions{
+		BaseDomain: "blahgeek.com",
+		Port:       53542,
+		HoldTime:   1000,
+	})
+	defer server.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case data := <-server.UpstreamBuf:
+				server.DownstreamBuf <- data
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	upstream := DNSTransportStream{}
+	upstream.codec, _ = NewDNSTransportUpstreamCodec("blahgeek.com", "")
+	downstream := DNSTransportStream{codec: NewDNSTransportDownstreamCodec()}
+
+	msg := []byte("hello, world")
+	names := upstream.Encode(msg)
+	if len(names) != 1 {
+		t.Fatalf("Message should not be fragmented")
+	}
+	txt, _ := exchangeTestDNS(t, names[0], "53542")
+	if decoded := downstream.Decode(txt); bytes.Compare(decoded, msg) != 0 {
+		t.Errorf("Echoed msg mismatch: %v", decoded)
+	}
+}
+
+func TestDNSServerMaxQueriesPerClient(t *testing.T) {
+	server := openTestDNSServer(t, DNSTransportServerOptions{
+		BaseDomain:          "blahgeek.com",
+		Port:                53549,
+		HoldTime:            5000,
+		MaxQueriesPerClient: 1,
+	})
+	defer server.Close()
+	codec, _ := NewDNSTransportUpstreamCodec("blahgeek.com", "")
+
+	// same resolver, but from different source ports
+	var conns []*dns.Conn
+	for seq := uint32(1); seq <= 2; seq += 1 {
+		conn, err := dns.Dial("udp", "127.0.0.1:53549")
+		if err != nil {
+			t.Fatalf("Unable to dial: %v", err)
+		}
+		defer conn.Close()
+		m := new(dns.Msg)
+		m.SetQuestion(codec.Encode(nil, DNSCodecHeader{Seq: seq}), dns.TypeTXT)
+		if err = conn.WriteMsg(m); err != nil {
+			t.Fatalf("Unable to send query: %v", err)
+		}
+		conns = append(conns, conn)
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// first query should be answered now instead of being held
+	conns[0].SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conns[0].ReadMsg(); err != nil {
+		t.Errorf("Oldest query of client should be answered: %v", err)
+	}
+}
+
+func TestDNSServerForward(t *testing.T) {
+	// return -1 on error
+	exchange := func(port string) int {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeA)
+		client := dns.Client{Timeout: 3 * time.Second}
+		if reply, _, err := client.Exchange(m, "127.0.0.1:"+port); err == nil {
+			return reply.Rcode
+		}
+		return -1
+	}
+
+	server := openTestDNSServer(t, DNSTransportServerOptions{
+		BaseDomain: "blahgeek.com",
+		Port:       53547,
+	})
+	defer server.Close()
+	if rcode := exchange("53547"); rcode != dns.RcodeRefused {
+		t.Errorf("Query outside base domain should be refused by default: %v", rcode)
+	}
+
+	// resolver never replies
+	blackhole, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer blackhole.Close()
+	forwarder := openTestDNSServer(t, DNSTransportServerOptions{
+		BaseDomain:    "blahgeek.com",
+		Port:          53548,
+		Forward:       blackhole.LocalAddr().String(),
+		MaxForwarding: 1,
+	})
+	defer forwarder.Close()
+	go exchange("53548")
+	time.Sleep(100 * time.Millisecond)
+	if rcode := exchange("53548"); rcode != dns.RcodeServerFailure {
+		t.Errorf("Query should fail when too many are forwarding: %v", rcode)
+	}
+}
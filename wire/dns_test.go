@@ -0,0 +1,113 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-18
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-18
+ */
+
+package wire
+
+import "testing"
+import "bytes"
+import "time"
+import "net/http"
+import "net/http/httptest"
+import "github.com/miekg/dns"
+
+func TestDNSTransport(t *testing.T) {
+	testDNSTransport(t, []byte(`{"base_domain": "blahgeek.com", "port": 53543,
+	                             "resolver": "127.0.0.1:53543", "reliable": true}`))
+}
+
+func TestDNSTransportTCP(t *testing.T) {
+	testDNSTransport(t, []byte(`{"base_domain": "blahgeek.com", "port": 53544, "tcp": true,
+	                             "resolver": "127.0.0.1:53544", "reliable": true}`))
+}
+
+func TestDNSTransportDoH(t *testing.T) {
+	testDNSTransportPair(t, []byte(`{"base_domain": "blahgeek.com", "port": 53545, "reliable": true,
+	                                 "doh": "127.0.0.1:53545", "doh_path": "/q"}`),
+		[]byte(`{"base_domain": "blahgeek.com", "reliable": true,
+		         "doh": "http://127.0.0.1:53545/q", "doh_method": "post"}`))
+}
+
+func TestDNSTransportDoHGet(t *testing.T) {
+	testDNSTransportPair(t, []byte(`{"base_domain": "blahgeek.com", "port": 53546, "reliable": true,
+	                                 "doh": "127.0.0.1:53546"}`),
+		[]byte(`{"base_domain": "blahgeek.com", "reliable": true,
+		         "doh": "http://127.0.0.1:53546/dns-query", "doh_method": "get"}`))
+}
+
+func testDNSTransport(t *testing.T, options []byte) {
+	testDNSTransportPair(t, options, options)
+}
+
+func testDNSTransportPair(t *testing.T, server_options, client_options []byte) {
+	server, err := New("dns", true, server_options)
+	if err != nil {
+		t.Fatalf("Unable to open server: %v", err)
+	}
+	defer server.Close()
+	client, err := New("dns", false, client_options)
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	defer client.Close()
+
+	go func() {
+		buf := make([]byte, server.MTU())
+		for {
+			rdlen, err := server.Read(buf)
+			if err != nil {
+				return
+			}
+			server.Write(buf[:rdlen])
+		}
+	}()
+
+	msg := bytes.Repeat([]byte("justvpn"), client.MTU()/7)
+	buf := make([]byte, client.MTU())
+	for i := 0; i < 4; i += 1 {
+		client.Write(msg)
+		done := make(chan int)
+		go func() {
+			rdlen, _ := client.Read(buf)
+			done <- rdlen
+		}()
+		select {
+		case rdlen := <-done:
+			if bytes.Compare(buf[:rdlen], msg) != 0 {
+				t.Errorf("Echoed msg mismatch")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for echo")
+		}
+	}
+}
+
+func TestDNSHTTPSConnTimeout(t *testing.T) {
+	// never answer, until client gives up
+	gone := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readDNSFromHTTP(r)
+		<-r.Context().Done()
+		close(gone)
+	}))
+	defer server.Close()
+
+	conn, err := NewDNSHTTPSConn(server.URL, "post", server.Listener.Addr().String(), 1, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Unable to open conn: %v", err)
+	}
+	defer conn.Close()
+	m := new(dns.Msg)
+	m.SetQuestion("blahgeek.com.", dns.TypeTXT)
+	if err = conn.WriteDNS(m); err != nil {
+		t.Fatalf("Unable to send query: %v", err)
+	}
+	select {
+	case <-gone:
+	case <-time.After(2 * time.Second):
+		t.Errorf("Request should be given up after timeout")
+	}
+}
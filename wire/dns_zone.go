@@ -0,0 +1,163 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "fmt"
+import "net"
+import "time"
+import "strings"
+import "github.com/miekg/dns"
+
+type DNSZoneOptions struct {
+	// Name servers of base domain, first one is the primary in SOA
+	NS []string `json:"ns"`
+	// IPv4 addresses of base domain, also used for in-zone name servers
+	A []string `json:"a"`
+	// Mailbox of zone admin in SOA, default to hostmaster.<base domain>
+	Mbox string `json:"mbox"`
+	// TTL of SOA, NS and A records
+	TTL int `json:"ttl"`
+}
+
+const DNSZONE_DEFAULT_TTL = 300
+
+// Answers non-tunnel queries like an authoritative server of base domain
+type DNSZone struct {
+	domain string
+	ttl    uint32
+	soa    *dns.SOA
+	ns     []string
+	a      []net.IP
+}
+
+func NewDNSZone(domain string, options DNSZoneOptions) (*DNSZone, error) {
+	zone := &DNSZone{domain: dns.Fqdn(strings.ToLower(domain))}
+	zone.ttl = DNSZONE_DEFAULT_TTL
+	if options.TTL > 0 {
+		zone.ttl = uint32(options.TTL)
+	}
+
+	for _, ns := range options.NS {
+		zone.ns = append(zone.ns, dns.Fqdn(strings.ToLower(ns)))
+	}
+	if len(zone.ns) == 0 {
+		zone.ns = []string{"ns." + zone.domain}
+	}
+	for _, a := range options.A {
+		ip := net.ParseIP(a).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("Bad IPv4 address for zone: %v", a)
+		}
+		zone.a = append(zone.a, ip)
+	}
+
+	mbox := "hostmaster." + zone.domain
+	if len(options.Mbox) > 0 {
+		mbox = dns.Fqdn(options.Mbox)
+	}
+	zone.soa = &dns.SOA{
+		Hdr:     zone.header(zone.domain, dns.TypeSOA),
+		Ns:      zone.ns[0],
+		Mbox:    mbox,
+		Serial:  uint32(time.Now().Unix()),
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		// tunnel names must never be negatively cached
+		Minttl: 0,
+	}
+	return zone, nil
+}
+
+// Return whether the name is base domain or its subdomain
+func (zone *DNSZone) Contains(name string) bool {
+	return dns.IsSubDomain(zone.domain, strings.ToLower(name))
+}
+
+// Build reply for non-tunnel query
+func (zone *DNSZone) Answer(req *dns.Msg) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetReply(req)
+	if len(req.Question) == 0 || req.Opcode != dns.OpcodeQuery {
+		reply.Rcode = dns.RcodeNotImplemented
+		return reply
+	}
+	q := req.Question[0]
+	name := strings.ToLower(q.Name)
+	if !zone.Contains(name) {
+		reply.Rcode = dns.RcodeRefused
+		return reply
+	}
+	reply.Authoritative = true
+
+	switch {
+	case name == zone.domain:
+		if q.Qtype == dns.TypeSOA || q.Qtype == dns.TypeANY {
+			reply.Answer = append(reply.Answer, zone.soa)
+		}
+		if q.Qtype == dns.TypeNS || q.Qtype == dns.TypeANY {
+			for _, ns := range zone.ns {
+				reply.Answer = append(reply.Answer, &dns.NS{
+					Hdr: zone.header(q.Name, dns.TypeNS),
+					Ns:  ns,
+				})
+			}
+			reply.Extra = append(reply.Extra, zone.glue()...)
+		}
+		if q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY {
+			reply.Answer = append(reply.Answer, zone.records(q.Name)...)
+		}
+	case zone.isNS(name):
+		if q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY {
+			reply.Answer = append(reply.Answer, zone.records(q.Name)...)
+		}
+	case q.Qtype == dns.TypeTXT:
+		// TXT queries for names in zone are all tunnel queries, so this is a bad one
+		reply.Rcode = dns.RcodeNameError
+	default:
+		// Other names may be empty non-terminals of tunnel names
+		// (e.g. from QNAME minimization), answer NODATA but not NXDOMAIN
+	}
+
+	if len(reply.Answer) == 0 {
+		reply.Ns = append(reply.Ns, zone.soa)
+	}
+	return reply
+}
+
+func (zone *DNSZone) header(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: zone.ttl}
+}
+
+func (zone *DNSZone) isNS(name string) bool {
+	for _, ns := range zone.ns {
+		if ns == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (zone *DNSZone) records(name string) []dns.RR {
+	var ret []dns.RR
+	for _, ip := range zone.a {
+		ret = append(ret, &dns.A{Hdr: zone.header(name, dns.TypeA), A: ip})
+	}
+	return ret
+}
+
+// A records of in-zone name servers
+func (zone *DNSZone) glue() []dns.RR {
+	var ret []dns.RR
+	for _, ns := range zone.ns {
+		if zone.Contains(ns) {
+			ret = append(ret, zone.records(ns)...)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,49 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "testing"
+import "github.com/miekg/dns"
+
+func TestDNSZone(t *testing.T) {
+	zone, err := NewDNSZone("x.blahgeek.com", DNSZoneOptions{
+		NS: []string{"ns1.x.blahgeek.com"},
+		A:  []string{"192.0.2.1"},
+	})
+	if err != nil {
+		t.Fatalf("Unable to build zone: %v", err)
+	}
+
+	ask := func(name string, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		return zone.Answer(req)
+	}
+
+	if reply := ask("X.blahgeek.com.", dns.TypeSOA); len(reply.Answer) != 1 || !reply.Authoritative {
+		t.Errorf("Bad SOA reply: %v", reply)
+	}
+	if reply := ask("x.blahgeek.com.", dns.TypeNS); len(reply.Answer) != 1 || len(reply.Extra) != 1 {
+		t.Errorf("Bad NS reply: %v", reply)
+	}
+	if reply := ask("x.blahgeek.com.", dns.TypeA); len(reply.Answer) != 1 {
+		t.Errorf("Bad A reply: %v", reply)
+	}
+	if reply := ask("ns1.x.blahgeek.com.", dns.TypeA); len(reply.Answer) != 1 {
+		t.Errorf("Bad A reply for name server: %v", reply)
+	}
+	if reply := ask("foo.x.blahgeek.com.", dns.TypeTXT); reply.Rcode != dns.RcodeNameError || len(reply.Ns) != 1 {
+		t.Errorf("Bad TXT query should be NXDOMAIN: %v", reply)
+	}
+	if reply := ask("foo.x.blahgeek.com.", dns.TypeNS); reply.Rcode != dns.RcodeSuccess || len(reply.Answer) != 0 {
+		t.Errorf("Empty non-terminal should be NODATA: %v", reply)
+	}
+	if reply := ask("blahgeek.com.", dns.TypeA); reply.Rcode != dns.RcodeRefused {
+		t.Errorf("Name outside zone should be refused: %v", reply)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "time"
+import "bytes"
+import "testing"
+
+// Echo server and reader of client, shared by tests of wires
+type testEcho struct {
+	client   Transport
+	received chan []byte
+}
+
+// Write packets read by server back (unless server is nil, e.g. it's
+// already echoing or the other end echoes by itself),
+// and read packets from client into received
+func newTestEcho(server, client Transport) *testEcho {
+	if server != nil {
+		go func() {
+			buf := make([]byte, server.MTU())
+			for {
+				rdlen, err := server.Read(buf)
+				if err != nil {
+					return
+				}
+				server.Write(buf[:rdlen])
+			}
+		}()
+	}
+	e := &testEcho{
+		client:   client,
+		received: make(chan []byte, 64),
+	}
+	go func() {
+		for {
+			buf := make([]byte, client.MTU())
+			rdlen, err := client.Read(buf)
+			if err != nil {
+				return
+			}
+			e.received <- buf[:rdlen]
+		}
+	}()
+	return e
+}
+
+// Packets written while not connected are dropped, keep sending msg until
+// it's echoed. Return false on timeout
+func (e *testEcho) Echo(msg []byte, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		e.client.Write(msg)
+		select {
+		case data := <-e.received:
+			if bytes.Equal(data, msg) {
+				return true
+			}
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// Echo each msg in order, fail the test on timeout
+func (e *testEcho) EchoAll(t *testing.T, msgs ...[]byte) {
+	for _, msg := range msgs {
+		if !e.Echo(msg, 5*time.Second) {
+			t.Fatalf("Timeout waiting for echo of %v bytes", len(msg))
+		}
+	}
+}
+
+// Start echoing between server and client, and echo each msg
+func testTransportEcho(t *testing.T, server, client Transport, msgs ...[]byte) *testEcho {
+	e := newTestEcho(server, client)
+	e.EchoAll(t, msgs...)
+	return e
+}
@@ -0,0 +1,489 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "io"
+import "net"
+import "fmt"
+import "sync"
+import "time"
+import "math/rand"
+import "encoding/binary"
+import "encoding/json"
+import log "github.com/Sirupsen/logrus"
+
+// Packets in hand-crafted TCP segments over raw sockets (IPv4 only, requires root).
+//
+// Connection starts with a real three-way handshake, then each packet is sent
+// as one PSH/ACK segment with plausible sequence and ACK numbers, but nothing
+// is retransmitted. Client sends keepalives and starts over with a new port
+// if it hears nothing from server for a while.
+//
+// Kernel does not know about these connections and would answer them with RST,
+// which should be dropped by firewall, e.g. on server:
+//   iptables -A OUTPUT -p tcp --sport <port> --tcp-flags RST RST -j DROP
+// and on client:
+//   iptables -A OUTPUT -p tcp -d <server> --dport <port> --tcp-flags RST RST -j DROP
+
+const FAKETCP_DEFAULT_MTU = 1400
+const FAKETCP_CHANNEL_BUFFER = 256
+const FAKETCP_MAX_PACKET_SIZE = 65535
+const FAKETCP_SYN_INTERVAL = time.Second
+const FAKETCP_KEEPALIVE_INTERVAL = 10 * time.Second
+const FAKETCP_TIMEOUT = 30 * time.Second
+const FAKETCP_WINDOW = 65535
+const FAKETCP_MSS = 1460
+
+const FAKETCP_HEADER_LEN = 20
+const (
+	FAKETCP_FIN = 0x01
+	FAKETCP_SYN = 0x02
+	FAKETCP_RST = 0x04
+	FAKETCP_PSH = 0x08
+	FAKETCP_ACK = 0x10
+)
+
+type FakeTCPTransportOptions struct {
+	ServerAddr string `json:"server_addr"`
+	// Client side, local address, random port if not set
+	ClientAddr string  `json:"client_addr"`
+	MTU        float64 `json:"mtu"`
+}
+
+type fakeTCPSegment struct {
+	src_port, dst_port uint16
+	seq, ack           uint32
+	flags              byte
+	payload            []byte
+}
+
+type fakeTCPConn struct {
+	remote      *net.IPAddr
+	remote_port uint16
+	local_ip    net.IP
+	local_port  uint16
+
+	snd_nxt, rcv_nxt uint32
+	established      bool
+	last_seen        time.Time
+}
+
+type FakeTCPTransport struct {
+	raw       net.PacketConn
+	is_server bool
+	mtu       int
+
+	server_addr *net.TCPAddr
+	client_addr *net.TCPAddr
+
+	lock sync.Mutex
+	// client side, current connection
+	conn *fakeTCPConn
+	// server side, connections by remote address, and the one used most recently
+	conns   map[string]*fakeTCPConn
+	current *fakeTCPConn
+
+	recv_buf    chan []byte
+	established chan struct{}
+	done        chan struct{}
+
+	logger *log.Entry
+}
+
+func (trans *FakeTCPTransport) String() string {
+	return fmt.Sprintf("FakeTCP[%v]", trans.server_addr)
+}
+
+func (trans *FakeTCPTransport) MTU() int {
+	return trans.mtu
+}
+
+func (trans *FakeTCPTransport) Open(is_server bool, options json.RawMessage) error {
+	trans.logger = log.WithField("logger", "FakeTCPTransport")
+	trans.is_server = is_server
+
+	var opt FakeTCPTransportOptions
+	if err := json.Unmarshal(options, &opt); err != nil {
+		return err
+	}
+	trans.mtu = FAKETCP_DEFAULT_MTU
+	if opt.MTU > 0 {
+		trans.mtu = int(opt.MTU)
+	}
+
+	var err error
+	if trans.server_addr, err = net.ResolveTCPAddr("tcp4", opt.ServerAddr); err != nil {
+		return fmt.Errorf("Error resolving server addr: %v", err)
+	}
+	if len(opt.ClientAddr) > 0 {
+		if trans.client_addr, err = net.ResolveTCPAddr("tcp4", opt.ClientAddr); err != nil {
+			return fmt.Errorf("Error resolving client addr: %v", err)
+		}
+	}
+
+	listen_ip := "0.0.0.0"
+	if is_server && trans.server_addr.IP != nil {
+		listen_ip = trans.server_addr.IP.String()
+	}
+	if !is_server && trans.client_addr != nil && trans.client_addr.IP != nil {
+		listen_ip = trans.client_addr.IP.String()
+	}
+	if trans.raw, err = net.ListenPacket("ip4:tcp", listen_ip); err != nil {
+		return fmt.Errorf("Error opening raw socket: %v", err)
+	}
+
+	trans.recv_buf = make(chan []byte, FAKETCP_CHANNEL_BUFFER)
+	trans.done = make(chan struct{})
+
+	if is_server {
+		trans.logger.WithField("addr", trans.server_addr).Info("Listening on address")
+		trans.conns = make(map[string]*fakeTCPConn)
+		go trans.serverLoop()
+	} else {
+		trans.logger.WithField("server", trans.server_addr).Info("Connecting to address")
+		trans.established = make(chan struct{}, 1)
+		go trans.clientRecvLoop()
+		go trans.clientKeepConnected()
+	}
+	return nil
+}
+
+func (trans *FakeTCPTransport) GetWireNetworks() []net.IPNet {
+	if trans.is_server {
+		return make([]net.IPNet, 0)
+	}
+	return []net.IPNet{
+		net.IPNet{trans.server_addr.IP.To4(), net.CIDRMask(32, 32)},
+	}
+}
+
+func (trans *FakeTCPTransport) Close() error {
+	close(trans.done)
+	return trans.raw.Close()
+}
+
+func (trans *FakeTCPTransport) Read(buf []byte) (int, error) {
+	select {
+	case <-trans.done:
+		return 0, io.EOF
+	case data := <-trans.recv_buf:
+		return copy(buf, data), nil
+	}
+}
+
+func (trans *FakeTCPTransport) Write(buf []byte) (int, error) {
+	trans.lock.Lock()
+	conn := trans.conn
+	if trans.is_server {
+		conn = trans.current
+	}
+	if conn == nil || !conn.established {
+		// not connected, drop it
+		trans.lock.Unlock()
+		return len(buf), nil
+	}
+	seq, ack := conn.snd_nxt, conn.rcv_nxt
+	conn.snd_nxt += uint32(len(buf))
+	trans.lock.Unlock()
+
+	trans.send(conn, seq, ack, FAKETCP_PSH|FAKETCP_ACK, buf)
+	return len(buf), nil
+}
+
+func fakeTCPChecksum(src, dst net.IP, segment []byte) uint16 {
+	pseudo := make([]byte, 12, 12+len(segment))
+	copy(pseudo[0:], src.To4())
+	copy(pseudo[4:], dst.To4())
+	pseudo[9] = 6 // protocol
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(len(segment)))
+	return icmpChecksum(append(pseudo, segment...))
+}
+
+func (trans *FakeTCPTransport) send(conn *fakeTCPConn, seq, ack uint32, flags byte, payload []byte) {
+	header_len := FAKETCP_HEADER_LEN
+	if flags&FAKETCP_SYN != 0 {
+		// MSS option
+		header_len += 4
+	}
+	segment := make([]byte, header_len+len(payload))
+	binary.BigEndian.PutUint16(segment[0:], conn.local_port)
+	binary.BigEndian.PutUint16(segment[2:], conn.remote_port)
+	binary.BigEndian.PutUint32(segment[4:], seq)
+	if flags&FAKETCP_ACK != 0 {
+		binary.BigEndian.PutUint32(segment[8:], ack)
+	}
+	segment[12] = byte(header_len/4) << 4
+	segment[13] = flags
+	binary.BigEndian.PutUint16(segment[14:], FAKETCP_WINDOW)
+	if flags&FAKETCP_SYN != 0 {
+		segment[20], segment[21] = 2, 4
+		binary.BigEndian.PutUint16(segment[22:], FAKETCP_MSS)
+	}
+	copy(segment[header_len:], payload)
+	binary.BigEndian.PutUint16(segment[16:], fakeTCPChecksum(conn.local_ip, conn.remote.IP, segment))
+
+	if _, err := trans.raw.WriteTo(segment, conn.remote); err != nil {
+		trans.logger.WithField("error", err).Warning("Error writing segment")
+	}
+}
+
+func (trans *FakeTCPTransport) recv(buf []byte) (*net.IPAddr, *fakeTCPSegment, error) {
+	for {
+		rdlen, addr, err := trans.raw.ReadFrom(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rdlen < FAKETCP_HEADER_LEN {
+			continue
+		}
+		header_len := int(buf[12]>>4) * 4
+		if header_len < FAKETCP_HEADER_LEN || header_len > rdlen {
+			continue
+		}
+		seg := &fakeTCPSegment{
+			src_port: binary.BigEndian.Uint16(buf[0:]),
+			dst_port: binary.BigEndian.Uint16(buf[2:]),
+			seq:      binary.BigEndian.Uint32(buf[4:]),
+			ack:      binary.BigEndian.Uint32(buf[8:]),
+			flags:    buf[13],
+		}
+		if rdlen > header_len {
+			seg.payload = make([]byte, rdlen-header_len)
+			copy(seg.payload, buf[header_len:rdlen])
+		}
+		return addr.(*net.IPAddr), seg, nil
+	}
+}
+
+// Local address used to reach remote, for checksum
+func fakeTCPLocalIP(remote net.IP) (net.IP, error) {
+	conn, err := net.Dial("udp4", net.JoinHostPort(remote.String(), "9"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.To4(), nil
+}
+
+func (trans *FakeTCPTransport) deliver(conn *fakeTCPConn, seg *fakeTCPSegment) {
+	if end := seg.seq + uint32(len(seg.payload)); int32(end-conn.rcv_nxt) > 0 {
+		conn.rcv_nxt = end
+	}
+	conn.last_seen = time.Now()
+	if len(seg.payload) == 0 {
+		return
+	}
+	select {
+	case trans.recv_buf <- seg.payload:
+	default:
+		trans.logger.Debug("Receive buffer full, drop packet")
+	}
+}
+
+// Client side, handshake with a new port whenever connection is lost
+func (trans *FakeTCPTransport) clientKeepConnected() {
+	for {
+		conn := &fakeTCPConn{
+			remote:      &net.IPAddr{IP: trans.server_addr.IP},
+			remote_port: uint16(trans.server_addr.Port),
+			local_port:  uint16(20000 + rand.Intn(40000)),
+			snd_nxt:     rand.Uint32(),
+		}
+		if trans.client_addr != nil && trans.client_addr.Port != 0 {
+			conn.local_port = uint16(trans.client_addr.Port)
+		}
+		var err error
+		if trans.client_addr != nil && trans.client_addr.IP != nil {
+			conn.local_ip = trans.client_addr.IP.To4()
+		} else if conn.local_ip, err = fakeTCPLocalIP(conn.remote.IP); err != nil {
+			trans.logger.WithField("error", err).Warning("Error finding local address")
+			select {
+			case <-trans.done:
+				return
+			case <-time.After(FAKETCP_SYN_INTERVAL):
+			}
+			continue
+		}
+		trans.lock.Lock()
+		trans.conn = conn
+		trans.lock.Unlock()
+
+		// send SYN until established
+		isn := conn.snd_nxt
+		for !trans.isEstablished(conn) {
+			trans.send(conn, isn, 0, FAKETCP_SYN, nil)
+			select {
+			case <-trans.done:
+				return
+			case <-trans.established:
+			case <-time.After(FAKETCP_SYN_INTERVAL):
+			}
+		}
+		trans.logger.WithField("port", conn.local_port).Info("Connected")
+
+		// keepalive until timeout
+		for {
+			select {
+			case <-trans.done:
+				return
+			case <-time.After(FAKETCP_KEEPALIVE_INTERVAL):
+			}
+			trans.lock.Lock()
+			seq, ack, last_seen := conn.snd_nxt, conn.rcv_nxt, conn.last_seen
+			trans.lock.Unlock()
+			if time.Since(last_seen) > FAKETCP_TIMEOUT {
+				trans.logger.Warning("Connection timeout, reconnect")
+				break
+			}
+			trans.send(conn, seq, ack, FAKETCP_ACK, nil)
+		}
+	}
+}
+
+func (trans *FakeTCPTransport) isEstablished(conn *fakeTCPConn) bool {
+	trans.lock.Lock()
+	defer trans.lock.Unlock()
+	return conn.established
+}
+
+func (trans *FakeTCPTransport) clientRecvLoop() {
+	buf := make([]byte, FAKETCP_MAX_PACKET_SIZE)
+	for {
+		addr, seg, err := trans.recv(buf)
+		if err != nil {
+			select {
+			case <-trans.done:
+				return
+			default:
+			}
+			trans.logger.WithField("error", err).Warning("Error reading segment")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		trans.lock.Lock()
+		conn := trans.conn
+		if conn == nil || !addr.IP.Equal(conn.remote.IP) ||
+			seg.src_port != conn.remote_port || seg.dst_port != conn.local_port ||
+			seg.flags&FAKETCP_RST != 0 {
+			trans.lock.Unlock()
+			continue
+		}
+		if seg.flags&(FAKETCP_SYN|FAKETCP_ACK) == FAKETCP_SYN|FAKETCP_ACK {
+			// SYN-ACK, (re)send ACK to complete handshake
+			if !conn.established && seg.ack == conn.snd_nxt+1 {
+				conn.snd_nxt += 1
+				conn.rcv_nxt = seg.seq + 1
+				conn.last_seen = time.Now()
+				conn.established = true
+				select {
+				case trans.established <- struct{}{}:
+				default:
+				}
+			}
+			seq, ack := conn.snd_nxt, conn.rcv_nxt
+			trans.lock.Unlock()
+			trans.send(conn, seq, ack, FAKETCP_ACK, nil)
+			continue
+		}
+		if conn.established {
+			trans.deliver(conn, seg)
+		}
+		trans.lock.Unlock()
+	}
+}
+
+// Server side, handle handshakes, data and keepalives of all connections
+func (trans *FakeTCPTransport) serverLoop() {
+	buf := make([]byte, FAKETCP_MAX_PACKET_SIZE)
+	last_cleanup := time.Now()
+	for {
+		addr, seg, err := trans.recv(buf)
+		if err != nil {
+			select {
+			case <-trans.done:
+				return
+			default:
+			}
+			trans.logger.WithField("error", err).Warning("Error reading segment")
+			time.Sleep(time.Second)
+			continue
+		}
+		if seg.dst_port != uint16(trans.server_addr.Port) || seg.flags&FAKETCP_RST != 0 {
+			continue
+		}
+		key := net.JoinHostPort(addr.IP.String(), fmt.Sprint(seg.src_port))
+
+		trans.lock.Lock()
+		if now := time.Now(); now.Sub(last_cleanup) > FAKETCP_TIMEOUT {
+			last_cleanup = now
+			for k, c := range trans.conns {
+				if now.Sub(c.last_seen) > 2*FAKETCP_TIMEOUT {
+					delete(trans.conns, k)
+					if trans.current == c {
+						trans.current = nil
+					}
+				}
+			}
+		}
+		conn, exist := trans.conns[key]
+
+		if seg.flags&FAKETCP_SYN != 0 {
+			if exist && conn.established && seg.seq+1 == conn.rcv_nxt {
+				// duplicated SYN of established connection
+				trans.lock.Unlock()
+				continue
+			}
+			if !exist || seg.seq+1 != conn.rcv_nxt {
+				local_ip, err := fakeTCPLocalIP(addr.IP)
+				if err != nil {
+					trans.lock.Unlock()
+					trans.logger.WithField("error", err).Warning("Error finding local address")
+					continue
+				}
+				trans.logger.WithField("remote", key).Info("New connection")
+				conn = &fakeTCPConn{
+					remote:      addr,
+					remote_port: seg.src_port,
+					local_ip:    local_ip,
+					local_port:  seg.dst_port,
+					snd_nxt:     rand.Uint32(),
+					rcv_nxt:     seg.seq + 1,
+					last_seen:   time.Now(),
+				}
+				trans.conns[key] = conn
+			}
+			// (re)send SYN-ACK, sequence number is not advanced until handshake completes
+			seq, ack := conn.snd_nxt, conn.rcv_nxt
+			trans.lock.Unlock()
+			trans.send(conn, seq, ack, FAKETCP_SYN|FAKETCP_ACK, nil)
+			continue
+		}
+		if !exist || seg.flags&FAKETCP_ACK == 0 {
+			trans.lock.Unlock()
+			continue
+		}
+		if !conn.established {
+			if seg.ack != conn.snd_nxt+1 {
+				trans.lock.Unlock()
+				continue
+			}
+			conn.snd_nxt += 1
+			conn.established = true
+		}
+		trans.current = conn
+		trans.deliver(conn, seg)
+		seq, ack := conn.snd_nxt, conn.rcv_nxt
+		trans.lock.Unlock()
+
+		if len(seg.payload) == 0 {
+			// answer keepalive
+			trans.send(conn, seq, ack, FAKETCP_ACK, nil)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "net"
+import "bytes"
+import "testing"
+
+func TestFakeTCPChecksum(t *testing.T) {
+	src, dst := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	segment := make([]byte, FAKETCP_HEADER_LEN+3)
+	segment[12] = 5 << 4
+	segment[13] = FAKETCP_SYN
+	copy(segment[FAKETCP_HEADER_LEN:], "abc")
+	sum := fakeTCPChecksum(src, dst, segment)
+	segment[16], segment[17] = byte(sum>>8), byte(sum)
+	if fakeTCPChecksum(src, dst, segment) != 0 {
+		t.Errorf("Bad checksum: %x", sum)
+	}
+}
+
+// Requires raw socket, run as root (e.g. between network namespaces)
+func TestFakeTCPTransport(t *testing.T) {
+	options := []byte(`{"server_addr": "127.0.0.1:53601"}`)
+	server, err := New("faketcp", true, options)
+	if err != nil {
+		t.Skipf("Unable to open server: %v", err)
+	}
+	defer server.Close()
+	client, err := New("faketcp", false, options)
+	if err != nil {
+		t.Skipf("Unable to open client: %v", err)
+	}
+	defer client.Close()
+
+	// packets written before handshake completes are dropped
+	testTransportEcho(t, server, client, bytes.Repeat([]byte("justvpn"), client.MTU()/7))
+}
@@ -0,0 +1,575 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "io"
+import "net"
+import "fmt"
+import "sync"
+import "time"
+import "bytes"
+import "context"
+import "net/url"
+import "net/http"
+import "net/http/cookiejar"
+import "crypto/tls"
+import "crypto/rand"
+import "encoding/hex"
+import "encoding/binary"
+import "encoding/json"
+import log "github.com/Sirupsen/logrus"
+
+// HTTP long polling: upstream packets are sent in POST bodies,
+// downstream packets are received via GET requests held by server.
+// Bodies are batches of packets, each prefixed with two-byte length.
+// Clients are identified by session cookie.
+
+const HTTP_DEFAULT_MTU = 1400
+const HTTP_DEFAULT_HOLD_TIME = 20000
+const HTTP_DEFAULT_POLLS = 2
+const HTTP_DEFAULT_SESSION_TIMEOUT = 60000
+const HTTP_MAX_BATCH_SIZE = 64 * 1024
+const HTTP_CHANNEL_BUFFER = 256
+const HTTP_SESSION_COOKIE = "sid"
+const HTTP_SESSION_ID_LEN = 16
+const HTTP_CONTENT_TYPE = "application/octet-stream"
+
+type HTTPTransportOptions struct {
+	// server_addr is the address to listen on for server (may be empty if only
+	// used as http.Handler), or the address to connect to for client
+	// (default to host of url, ignored if proxy is used). cert and key enables
+	// TLS on server, ca, pin_sha256 and sni are used for "https" url.
+	// proxy (HTTP or SOCKS5) defaults to environment (HTTP_PROXY etc.)
+	TLSTransportOptions
+
+	// Client side, e.g. "https://example.com/poll"
+	URL string `json:"url"`
+	// Client side, Host header, default to host of url
+	Host string `json:"host"`
+	// Client side, extra headers of requests
+	Headers map[string]string `json:"headers"`
+	// Client side, number of concurrent GET requests, default to 2.
+	// Batches of concurrent requests may arrive out of order if more than 1
+	Polls int `json:"polls"`
+	// Server side, path to serve, default to "/"
+	Path string `json:"path"`
+	// Max time (in milliseconds) server holds a GET request
+	HoldTime int `json:"hold_time"`
+	// Server side, time (in milliseconds) before an idle session is removed
+	SessionTimeout int `json:"session_timeout"`
+}
+
+type httpSession struct {
+	id        string
+	send_buf  chan []byte // downstream packets
+	last_seen time.Time
+}
+
+type HTTPTransport struct {
+	is_server bool
+	mtu       int
+	hold_time time.Duration
+	options   HTTPTransportOptions
+
+	// client side
+	client      *http.Client
+	url         string
+	remote_addr *net.TCPAddr // server or proxy
+	send_buf    chan []byte
+
+	// server side
+	listener        net.Listener
+	sessions        map[string]*httpSession
+	current         *httpSession // session to write to, which is used most recently
+	sessions_lock   sync.Mutex
+	session_timeout time.Duration
+
+	recv_buf chan []byte
+	done     chan struct{}
+
+	logger *log.Entry
+}
+
+func (trans *HTTPTransport) String() string {
+	if trans.is_server {
+		return fmt.Sprintf("HTTP[%v]", trans.options.ServerAddr)
+	}
+	return fmt.Sprintf("HTTP[%v]", trans.url)
+}
+
+func (trans *HTTPTransport) MTU() int {
+	return trans.mtu
+}
+
+func (trans *HTTPTransport) Open(is_server bool, options json.RawMessage) error {
+	trans.logger = log.WithField("logger", "HTTPTransport")
+	trans.is_server = is_server
+
+	if err := json.Unmarshal(options, &trans.options); err != nil {
+		return err
+	}
+	trans.mtu = HTTP_DEFAULT_MTU
+	if trans.options.MTU > 0 {
+		trans.mtu = int(trans.options.MTU)
+	}
+	if trans.mtu > 0xffff {
+		trans.mtu = 0xffff
+	}
+	if trans.options.HoldTime <= 0 {
+		trans.options.HoldTime = HTTP_DEFAULT_HOLD_TIME
+	}
+	trans.hold_time = time.Duration(trans.options.HoldTime) * time.Millisecond
+
+	trans.recv_buf = make(chan []byte, HTTP_CHANNEL_BUFFER)
+	trans.done = make(chan struct{})
+
+	if is_server {
+		return trans.openServer()
+	}
+	return trans.openClient()
+}
+
+func (trans *HTTPTransport) GetWireNetworks() []net.IPNet {
+	if trans.is_server {
+		return make([]net.IPNet, 0)
+	}
+	ip := trans.remote_addr.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	mask_len := len(ip) * 8
+	return []net.IPNet{
+		net.IPNet{ip, net.CIDRMask(mask_len, mask_len)},
+	}
+}
+
+func (trans *HTTPTransport) Close() error {
+	close(trans.done)
+	if trans.client != nil {
+		trans.client.Transport.(*http.Transport).CloseIdleConnections()
+	}
+	if trans.listener != nil {
+		return trans.listener.Close()
+	}
+	return nil
+}
+
+func (trans *HTTPTransport) Read(buf []byte) (int, error) {
+	select {
+	case <-trans.done:
+		return 0, io.EOF
+	case data := <-trans.recv_buf:
+		return copy(buf, data), nil
+	}
+}
+
+func (trans *HTTPTransport) Write(buf []byte) (int, error) {
+	send_buf := trans.send_buf
+	if trans.is_server {
+		trans.sessions_lock.Lock()
+		if trans.current != nil {
+			send_buf = trans.current.send_buf
+		}
+		trans.sessions_lock.Unlock()
+		if send_buf == nil {
+			// no client yet, drop it
+			return len(buf), nil
+		}
+	}
+
+	data := make([]byte, len(buf))
+	copy(data, buf)
+	select {
+	case send_buf <- data:
+	default:
+		trans.logger.Debug("Send buffer full, drop packet")
+	}
+	return len(buf), nil
+}
+
+// Read packets from channel into a batch, blocking until the first one is available
+func (trans *HTTPTransport) batch(ctx context.Context, c chan []byte) ([]byte, bool) {
+	var buf bytes.Buffer
+	var length [2]byte
+	append_frame := func(data []byte) {
+		binary.BigEndian.PutUint16(length[:], uint16(len(data)))
+		buf.Write(length[:])
+		buf.Write(data)
+	}
+
+	select {
+	case <-trans.done:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	case data := <-c:
+		append_frame(data)
+	}
+	for buf.Len() < HTTP_MAX_BATCH_SIZE-trans.mtu-2 {
+		select {
+		case data := <-c:
+			append_frame(data)
+			continue
+		default:
+		}
+		break
+	}
+	return buf.Bytes(), true
+}
+
+// Parse batch of packets, put them into recv_buf
+func (trans *HTTPTransport) unbatch(ctx context.Context, body io.Reader) error {
+	var length [2]byte
+	for {
+		if _, err := io.ReadFull(body, length[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		data := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(body, data); err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		select {
+		case <-trans.done:
+			return io.EOF
+		case <-ctx.Done():
+			return ctx.Err()
+		case trans.recv_buf <- data:
+		}
+	}
+}
+
+func (trans *HTTPTransport) openClient() error {
+	u, err := url.Parse(trans.options.URL)
+	if err != nil {
+		return fmt.Errorf("Bad url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Bad url scheme: %v", u.Scheme)
+	}
+	trans.url = u.String()
+	if trans.options.Polls <= 0 {
+		trans.options.Polls = HTTP_DEFAULT_POLLS
+	}
+
+	var proxy *url.URL
+	if len(trans.options.Proxy) > 0 {
+		if proxy, err = url.Parse(trans.options.Proxy); err != nil {
+			return fmt.Errorf("Bad proxy: %v", err)
+		}
+	} else if proxy, err = http.ProxyFromEnvironment(&http.Request{URL: u}); err != nil {
+		return fmt.Errorf("Bad proxy: %v", err)
+	}
+
+	// always connect to the resolved address, so that it's routed by wire
+	addr := trans.options.ServerAddr
+	if proxy != nil {
+		addr = hostPortOfURL(proxy)
+	} else if len(addr) == 0 {
+		addr = hostPortOfURL(u)
+	}
+	if trans.remote_addr, err = net.ResolveTCPAddr("tcp", addr); err != nil {
+		return fmt.Errorf("Error resolving server addr: %v", err)
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyURL(proxy),
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer := &net.Dialer{Timeout: TCP_DIAL_TIMEOUT}
+			return dialer.DialContext(ctx, network, trans.remote_addr.String())
+		},
+		MaxIdleConnsPerHost: trans.options.Polls + 1,
+	}
+	if u.Scheme == "https" {
+		if len(trans.options.SNI) == 0 {
+			trans.options.SNI = u.Hostname()
+		}
+		if transport.TLSClientConfig, err = trans.options.clientConfig(); err != nil {
+			return err
+		}
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	}
+	jar, _ := cookiejar.New(nil)
+	trans.client = &http.Client{
+		Transport: transport,
+		Jar:       jar,
+		Timeout:   trans.hold_time + TCP_DIAL_TIMEOUT + TCP_WRITE_TIMEOUT,
+	}
+	trans.send_buf = make(chan []byte, HTTP_CHANNEL_BUFFER)
+
+	trans.logger.WithFields(log.Fields{
+		"url":   trans.url,
+		"proxy": proxy,
+		"addr":  trans.remote_addr,
+	}).Info("Starting HTTP client")
+
+	// get session cookie first, so that all requests use the same session
+	go func() {
+		for interval := TCP_MIN_RECONNECT_INTERVAL; ; interval *= 2 {
+			resp, err := trans.request(context.Background(), http.MethodPost, nil)
+			if err == nil {
+				resp.Body.Close()
+				break
+			}
+			trans.logger.WithField("error", err).Warning("Error connecting")
+			if interval > TCP_MAX_RECONNECT_INTERVAL {
+				interval = TCP_MAX_RECONNECT_INTERVAL
+			}
+			select {
+			case <-trans.done:
+				return
+			case <-time.After(interval):
+			}
+		}
+		go trans.sendLoop()
+		for i := 0; i < trans.options.Polls; i += 1 {
+			go trans.pollLoop()
+		}
+	}()
+	return nil
+}
+
+func hostPortOfURL(u *url.URL) string {
+	port := u.Port()
+	if len(port) == 0 {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = SOCKS5_DEFAULT_PORT
+		default:
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (trans *HTTPTransport) request(ctx context.Context, method string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, trans.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range trans.options.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(trans.options.Host) > 0 {
+		req.Host = trans.options.Host
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", HTTP_CONTENT_TYPE)
+	}
+	resp, err := trans.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Bad response status: %v", resp.Status)
+	}
+	return resp, nil
+}
+
+// Client side, send batched packets in POST requests
+func (trans *HTTPTransport) sendLoop() {
+	for {
+		body, ok := trans.batch(context.Background(), trans.send_buf)
+		if !ok {
+			return
+		}
+		resp, err := trans.request(context.Background(), http.MethodPost, body)
+		if err != nil {
+			trans.logger.WithField("error", err).Warning("Error sending packets")
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+// Client side, receive batched packets from GET requests
+func (trans *HTTPTransport) pollLoop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-trans.done
+		cancel()
+	}()
+
+	interval := TCP_MIN_RECONNECT_INTERVAL
+	for {
+		resp, err := trans.request(ctx, http.MethodGet, nil)
+		if err == nil {
+			err = trans.unbatch(ctx, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-trans.done:
+			return
+		default:
+		}
+		if err == nil {
+			interval = TCP_MIN_RECONNECT_INTERVAL
+			continue
+		}
+		trans.logger.WithField("error", err).Warning("Error polling")
+		select {
+		case <-trans.done:
+			return
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > TCP_MAX_RECONNECT_INTERVAL {
+			interval = TCP_MAX_RECONNECT_INTERVAL
+		}
+	}
+}
+
+func (trans *HTTPTransport) openServer() error {
+	if len(trans.options.Path) == 0 {
+		trans.options.Path = "/"
+	}
+	if trans.options.SessionTimeout <= 0 {
+		trans.options.SessionTimeout = HTTP_DEFAULT_SESSION_TIMEOUT
+	}
+	trans.session_timeout = time.Duration(trans.options.SessionTimeout) * time.Millisecond
+	trans.sessions = make(map[string]*httpSession)
+
+	// remove idle sessions
+	go func() {
+		ticker := time.NewTicker(trans.session_timeout / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-trans.done:
+				return
+			case <-ticker.C:
+			}
+			deadline := time.Now().Add(-trans.session_timeout)
+			trans.sessions_lock.Lock()
+			for id, session := range trans.sessions {
+				if session.last_seen.Before(deadline) {
+					trans.logger.WithField("session", id).Info("Session expired")
+					delete(trans.sessions, id)
+					if trans.current == session {
+						trans.current = nil
+					}
+				}
+			}
+			trans.sessions_lock.Unlock()
+		}
+	}()
+
+	if len(trans.options.ServerAddr) == 0 {
+		return nil
+	}
+	listener, err := net.Listen("tcp", trans.options.ServerAddr)
+	if err != nil {
+		return fmt.Errorf("Error listening HTTP: %v", err)
+	}
+	trans.listener = listener
+	if len(trans.options.Cert) > 0 {
+		cert, err := tls.LoadX509KeyPair(trans.options.Cert, trans.options.Key)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("Error loading certificate: %v", err)
+		}
+		trans.listener = tls.NewListener(listener, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"http/1.1"},
+			MinVersion:   tls.VersionTLS12,
+		})
+	}
+	trans.logger.WithFields(log.Fields{
+		"addr": trans.options.ServerAddr,
+		"path": trans.options.Path,
+	}).Info("Listening on address")
+
+	mux := http.NewServeMux()
+	mux.Handle(trans.options.Path, trans)
+	go func() {
+		err := http.Serve(trans.listener, mux)
+		select {
+		case <-trans.done:
+		default:
+			trans.logger.WithField("error", err).Error("HTTP server stopped")
+		}
+	}()
+	return nil
+}
+
+// Server side, find session by cookie, or create one.
+// Unknown (e.g. expired) session ID is reused, so that concurrent requests
+// from the same client would not end up in different sessions
+func (trans *HTTPTransport) session(w http.ResponseWriter, r *http.Request) *httpSession {
+	var id string
+	if cookie, err := r.Cookie(HTTP_SESSION_COOKIE); err == nil {
+		if raw, err := hex.DecodeString(cookie.Value); err == nil && len(raw) == HTTP_SESSION_ID_LEN {
+			id = cookie.Value
+		}
+	}
+	if len(id) == 0 {
+		raw := make([]byte, HTTP_SESSION_ID_LEN)
+		rand.Read(raw)
+		id = hex.EncodeToString(raw)
+		http.SetCookie(w, &http.Cookie{Name: HTTP_SESSION_COOKIE, Value: id, Path: "/", HttpOnly: true})
+	}
+
+	trans.sessions_lock.Lock()
+	defer trans.sessions_lock.Unlock()
+	session, ok := trans.sessions[id]
+	if !ok {
+		trans.logger.WithFields(log.Fields{
+			"session": id,
+			"remote":  r.RemoteAddr,
+		}).Info("New session")
+		session = &httpSession{id: id, send_buf: make(chan []byte, HTTP_CHANNEL_BUFFER)}
+		trans.sessions[id] = session
+	}
+	session.last_seen = time.Now()
+	trans.current = session
+	return session
+}
+
+// Server side, handle POST (upstream) and GET (downstream) requests
+func (trans *HTTPTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session := trans.session(w, r)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+
+	if r.Method == http.MethodPost {
+		if err := trans.unbatch(r.Context(), io.LimitReader(r.Body, HTTP_MAX_BATCH_SIZE)); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), trans.hold_time)
+	defer cancel()
+	body, ok := trans.batch(ctx, session.send_buf)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", HTTP_CONTENT_TYPE)
+	if _, err := w.Write(body); err != nil {
+		trans.logger.WithField("error", err).Debug("Error writing response, packets lost")
+	}
+
+	trans.sessions_lock.Lock()
+	session.last_seen = time.Now()
+	trans.sessions_lock.Unlock()
+}
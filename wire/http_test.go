@@ -0,0 +1,78 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "io"
+import "bytes"
+import "time"
+import "testing"
+import "sync/atomic"
+import "net/http"
+import "net/http/httptest"
+
+func TestHTTPTransport(t *testing.T) {
+	server, err := New("http", true, []byte(`{"server_addr": "127.0.0.1:53591", "path": "/poll",
+	                                          "hold_time": 1000}`))
+	if err != nil {
+		t.Fatalf("Unable to open server: %v", err)
+	}
+	defer server.Close()
+
+	// forward proxy stand-in
+	var proxied int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&proxied, 1)
+		r.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer proxy.Close()
+
+	client, err := New("http", false, []byte(`{"url": "http://127.0.0.1:53591/poll",
+	                                           "proxy": "`+proxy.URL+`", "hold_time": 1000,
+	                                           "polls": 1}`))
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	defer client.Close()
+
+	echo := newTestEcho(server, client)
+
+	// packets are queued before connected, and sent in batches,
+	// in order as there is only one poll
+	for i := 0; i < 32; i += 1 {
+		client.Write(bytes.Repeat([]byte{byte(i)}, 100+i))
+	}
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < 32; i += 1 {
+		select {
+		case data := <-echo.received:
+			if !bytes.Equal(data, bytes.Repeat([]byte{byte(i)}, 100+i)) {
+				t.Fatalf("Bad echo: %v", data)
+			}
+		case <-timeout:
+			t.Fatalf("Timeout waiting for echo")
+		}
+	}
+
+	if atomic.LoadInt32(&proxied) == 0 {
+		t.Errorf("Requests are not sent via proxy")
+	}
+	if n := len(server.(*HTTPTransport).sessions); n != 1 {
+		t.Errorf("Expect 1 session, got %v", n)
+	}
+}
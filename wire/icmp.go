@@ -0,0 +1,430 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "io"
+import "net"
+import "fmt"
+import "sync"
+import "time"
+import "math/rand"
+import "encoding/binary"
+import "encoding/json"
+import log "github.com/Sirupsen/logrus"
+
+// Packets in payload of ICMP echo (IPv4 only), requires raw socket (root).
+//
+// Client sends echo requests with its session ID as echo ID, server can only
+// send packets as echo replies matching ID and sequence of received requests,
+// so client polls with empty requests to keep server supplied.
+// Kernel of server also replies to requests by echoing the payload,
+// which is ignored by client as kinds of requests and replies differ (magic is the same),
+// but it's better to disable it (sysctl net.ipv4.icmp_echo_ignore_all=1).
+
+const ICMP_DEFAULT_MTU = 1400
+const ICMP_DEFAULT_POLL_INTERVAL = 50
+const ICMP_DEFAULT_MAX_POLL_INTERVAL = 1000
+const ICMP_DEFAULT_MAX_PENDING = 64
+const ICMP_PENDING_TTL = 10 * time.Second
+const ICMP_CHANNEL_BUFFER = 256
+const ICMP_MAX_PACKET_SIZE = 65535
+
+const ICMP_ECHO_REPLY = 0
+const ICMP_ECHO_REQUEST = 8
+const ICMP_HEADER_LEN = 8
+
+// [magic (2 bytes)][kind (1 byte)][data]
+const ICMP_PAYLOAD_HEADER_LEN = 3
+const ICMP_MAGIC = "jv"
+const (
+	ICMP_KIND_REQUEST_POLL = 'p'
+	ICMP_KIND_REQUEST_DATA = 'q'
+	ICMP_KIND_REPLY_EMPTY  = 'e'
+	ICMP_KIND_REPLY_DATA   = 'r'
+)
+
+type ICMPTransportOptions struct {
+	// Server side, address to listen on, default to all;
+	// client side, address of server
+	ServerAddr string  `json:"server_addr"`
+	MTU        float64 `json:"mtu"`
+	// Client side, min and max interval (in milliseconds) between polling requests,
+	// it backs off to the max while there is no downstream data
+	PollInterval    int `json:"poll_interval"`
+	MaxPollInterval int `json:"max_poll_interval"`
+	// Server side, max number of unanswered requests kept for each session
+	MaxPending int `json:"max_pending"`
+}
+
+type icmpPending struct {
+	seq  uint16
+	time time.Time
+}
+
+type icmpSession struct {
+	id        uint16
+	addr      *net.IPAddr
+	pending   []icmpPending // oldest first
+	last_seen time.Time
+}
+
+type ICMPTransport struct {
+	conn      net.PacketConn
+	is_server bool
+	mtu       int
+	options   ICMPTransportOptions
+
+	// client side
+	remote_addr   *net.IPAddr
+	id            uint16
+	seq           uint16
+	poll_interval time.Duration
+	got_data      chan struct{}
+
+	// server side
+	sessions map[uint16]*icmpSession
+	current  *icmpSession // session to write to, which is seen most recently
+
+	lock     sync.Mutex
+	send_buf chan []byte
+	recv_buf chan []byte
+	done     chan struct{}
+
+	logger *log.Entry
+}
+
+func (trans *ICMPTransport) String() string {
+	if trans.is_server {
+		trans.lock.Lock()
+		defer trans.lock.Unlock()
+		if trans.current == nil {
+			return "ICMP[]"
+		}
+		return fmt.Sprintf("ICMP[%v]", trans.current.addr)
+	}
+	return fmt.Sprintf("ICMP[%v]", trans.remote_addr)
+}
+
+func (trans *ICMPTransport) MTU() int {
+	return trans.mtu
+}
+
+func (trans *ICMPTransport) Open(is_server bool, options json.RawMessage) error {
+	trans.logger = log.WithField("logger", "ICMPTransport")
+	trans.is_server = is_server
+
+	if err := json.Unmarshal(options, &trans.options); err != nil {
+		return err
+	}
+	trans.mtu = ICMP_DEFAULT_MTU
+	if trans.options.MTU > 0 {
+		trans.mtu = int(trans.options.MTU)
+	}
+	if trans.options.PollInterval <= 0 {
+		trans.options.PollInterval = ICMP_DEFAULT_POLL_INTERVAL
+	}
+	if trans.options.MaxPollInterval <= 0 {
+		trans.options.MaxPollInterval = ICMP_DEFAULT_MAX_POLL_INTERVAL
+	}
+	if trans.options.MaxPending <= 0 {
+		trans.options.MaxPending = ICMP_DEFAULT_MAX_PENDING
+	}
+
+	listen_addr := "0.0.0.0"
+	if is_server {
+		if len(trans.options.ServerAddr) > 0 {
+			listen_addr = trans.options.ServerAddr
+		}
+	} else {
+		var err error
+		if trans.remote_addr, err = net.ResolveIPAddr("ip4", trans.options.ServerAddr); err != nil {
+			return fmt.Errorf("Error resolving server addr: %v", err)
+		}
+	}
+
+	var err error
+	if trans.conn, err = net.ListenPacket("ip4:icmp", listen_addr); err != nil {
+		return fmt.Errorf("Error opening raw socket: %v", err)
+	}
+
+	trans.send_buf = make(chan []byte, ICMP_CHANNEL_BUFFER)
+	trans.recv_buf = make(chan []byte, ICMP_CHANNEL_BUFFER)
+	trans.done = make(chan struct{})
+
+	if is_server {
+		trans.logger.WithField("addr", listen_addr).Info("Listening on address")
+		trans.sessions = make(map[uint16]*icmpSession)
+		go trans.serverLoop()
+	} else {
+		trans.id = uint16(rand.Uint32())
+		trans.seq = uint16(rand.Uint32())
+		trans.poll_interval = time.Duration(trans.options.PollInterval) * time.Millisecond
+		trans.got_data = make(chan struct{}, 1)
+		trans.logger.WithFields(log.Fields{
+			"server": trans.remote_addr,
+			"id":     trans.id,
+		}).Info("Starting ICMP client")
+		go trans.clientRecvLoop()
+		go trans.clientSendLoop()
+	}
+	return nil
+}
+
+func (trans *ICMPTransport) GetWireNetworks() []net.IPNet {
+	if trans.is_server {
+		return make([]net.IPNet, 0)
+	}
+	return []net.IPNet{
+		net.IPNet{trans.remote_addr.IP.To4(), net.CIDRMask(32, 32)},
+	}
+}
+
+func (trans *ICMPTransport) Close() error {
+	close(trans.done)
+	return trans.conn.Close()
+}
+
+func (trans *ICMPTransport) Read(buf []byte) (int, error) {
+	select {
+	case <-trans.done:
+		return 0, io.EOF
+	case data := <-trans.recv_buf:
+		return copy(buf, data), nil
+	}
+}
+
+func (trans *ICMPTransport) Write(buf []byte) (int, error) {
+	data := make([]byte, len(buf))
+	copy(data, buf)
+	if trans.is_server {
+		// send now if there is a pending request, queue it otherwise
+		if session, seq, ok := trans.popPending(); ok {
+			trans.send(session.addr, ICMP_ECHO_REPLY, session.id, seq, ICMP_KIND_REPLY_DATA, data)
+			return len(buf), nil
+		}
+	}
+	select {
+	case trans.send_buf <- data:
+	default:
+		trans.logger.Debug("Send buffer full, drop packet")
+	}
+	return len(buf), nil
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+func (trans *ICMPTransport) send(addr *net.IPAddr, icmp_type byte, id, seq uint16, kind byte, data []byte) {
+	msg := make([]byte, ICMP_HEADER_LEN+ICMP_PAYLOAD_HEADER_LEN+len(data))
+	msg[0] = icmp_type
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	copy(msg[ICMP_HEADER_LEN:], ICMP_MAGIC)
+	msg[ICMP_HEADER_LEN+2] = kind
+	copy(msg[ICMP_HEADER_LEN+ICMP_PAYLOAD_HEADER_LEN:], data)
+	binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+
+	if _, err := trans.conn.WriteTo(msg, addr); err != nil {
+		trans.logger.WithField("error", err).Warning("Error writing ICMP")
+	}
+}
+
+// Read next ICMP message of this transport, return its type, ID, sequence, kind and data
+func (trans *ICMPTransport) recv(buf []byte) (*net.IPAddr, byte, uint16, uint16, byte, []byte, error) {
+	for {
+		rdlen, addr, err := trans.conn.ReadFrom(buf)
+		if err != nil {
+			return nil, 0, 0, 0, 0, nil, err
+		}
+		msg := buf[:rdlen]
+		if len(msg) < ICMP_HEADER_LEN+ICMP_PAYLOAD_HEADER_LEN || msg[1] != 0 ||
+			string(msg[ICMP_HEADER_LEN:ICMP_HEADER_LEN+2]) != ICMP_MAGIC {
+			continue
+		}
+		if msg[0] != ICMP_ECHO_REQUEST && msg[0] != ICMP_ECHO_REPLY {
+			continue
+		}
+		data := make([]byte, len(msg)-ICMP_HEADER_LEN-ICMP_PAYLOAD_HEADER_LEN)
+		copy(data, msg[ICMP_HEADER_LEN+ICMP_PAYLOAD_HEADER_LEN:])
+		return addr.(*net.IPAddr), msg[0], binary.BigEndian.Uint16(msg[4:]),
+			binary.BigEndian.Uint16(msg[6:]), msg[ICMP_HEADER_LEN+2], data, nil
+	}
+}
+
+func (trans *ICMPTransport) deliver(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	select {
+	case trans.recv_buf <- data:
+	default:
+		trans.logger.Debug("Receive buffer full, drop packet")
+	}
+}
+
+// Client side, send queued packets as requests, or poll if idle
+func (trans *ICMPTransport) clientSendLoop() {
+	interval := trans.poll_interval
+	max_interval := time.Duration(trans.options.MaxPollInterval) * time.Millisecond
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-trans.done:
+			return
+		case data := <-trans.send_buf:
+			trans.seq += 1
+			trans.send(trans.remote_addr, ICMP_ECHO_REQUEST, trans.id, trans.seq, ICMP_KIND_REQUEST_DATA, data)
+		case <-trans.got_data:
+			// server used one request for data, give it another one
+			interval = trans.poll_interval
+			trans.seq += 1
+			trans.send(trans.remote_addr, ICMP_ECHO_REQUEST, trans.id, trans.seq, ICMP_KIND_REQUEST_POLL, nil)
+		case <-timer.C:
+			trans.seq += 1
+			trans.send(trans.remote_addr, ICMP_ECHO_REQUEST, trans.id, trans.seq, ICMP_KIND_REQUEST_POLL, nil)
+			if interval *= 2; interval > max_interval {
+				interval = max_interval
+			}
+			timer.Reset(interval)
+		}
+	}
+}
+
+func (trans *ICMPTransport) clientRecvLoop() {
+	buf := make([]byte, ICMP_MAX_PACKET_SIZE)
+	for {
+		addr, icmp_type, id, _, kind, data, err := trans.recv(buf)
+		if err != nil {
+			select {
+			case <-trans.done:
+				return
+			default:
+			}
+			trans.logger.WithField("error", err).Warning("Error reading ICMP")
+			time.Sleep(time.Second)
+			continue
+		}
+		if icmp_type != ICMP_ECHO_REPLY || id != trans.id || !addr.IP.Equal(trans.remote_addr.IP) ||
+			kind != ICMP_KIND_REPLY_DATA {
+			continue
+		}
+		trans.deliver(data)
+		select {
+		case trans.got_data <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Server side, receive requests, answer them with queued packets or keep them pending
+func (trans *ICMPTransport) serverLoop() {
+	buf := make([]byte, ICMP_MAX_PACKET_SIZE)
+	for {
+		addr, icmp_type, id, seq, kind, data, err := trans.recv(buf)
+		if err != nil {
+			select {
+			case <-trans.done:
+				return
+			default:
+			}
+			trans.logger.WithField("error", err).Warning("Error reading ICMP")
+			time.Sleep(time.Second)
+			continue
+		}
+		if icmp_type != ICMP_ECHO_REQUEST ||
+			(kind != ICMP_KIND_REQUEST_DATA && kind != ICMP_KIND_REQUEST_POLL) {
+			continue
+		}
+		trans.deliver(data)
+
+		select {
+		case queued := <-trans.send_buf:
+			trans.send(addr, ICMP_ECHO_REPLY, id, seq, ICMP_KIND_REPLY_DATA, queued)
+			trans.touchSession(id, addr)
+		default:
+			if dropped, ok := trans.pushPending(id, addr, seq); ok {
+				trans.send(addr, ICMP_ECHO_REPLY, id, dropped, ICMP_KIND_REPLY_EMPTY, nil)
+			}
+		}
+	}
+}
+
+// lock must be held
+func (trans *ICMPTransport) getSession(id uint16, addr *net.IPAddr) *icmpSession {
+	now := time.Now()
+	session, ok := trans.sessions[id]
+	if !ok || !session.addr.IP.Equal(addr.IP) {
+		trans.logger.WithFields(log.Fields{
+			"id":   id,
+			"addr": addr,
+		}).Info("New session")
+		for old_id, old := range trans.sessions {
+			if now.Sub(old.last_seen) > ICMP_PENDING_TTL {
+				delete(trans.sessions, old_id)
+			}
+		}
+		session = &icmpSession{id: id, addr: addr}
+		trans.sessions[id] = session
+	}
+	session.last_seen = now
+	trans.current = session
+	return session
+}
+
+func (trans *ICMPTransport) touchSession(id uint16, addr *net.IPAddr) {
+	trans.lock.Lock()
+	defer trans.lock.Unlock()
+	trans.getSession(id, addr)
+}
+
+// Keep request pending, return sequence of the oldest one if it's dropped
+func (trans *ICMPTransport) pushPending(id uint16, addr *net.IPAddr, seq uint16) (uint16, bool) {
+	trans.lock.Lock()
+	defer trans.lock.Unlock()
+	session := trans.getSession(id, addr)
+	session.pending = append(session.pending, icmpPending{seq, time.Now()})
+	if len(session.pending) > trans.options.MaxPending {
+		dropped := session.pending[0]
+		session.pending = session.pending[1:]
+		return dropped.seq, true
+	}
+	return 0, false
+}
+
+// Pop newest pending request of current session, which is least likely
+// to be expired in NAT
+func (trans *ICMPTransport) popPending() (*icmpSession, uint16, bool) {
+	trans.lock.Lock()
+	defer trans.lock.Unlock()
+	session := trans.current
+	if session == nil {
+		return nil, 0, false
+	}
+	deadline := time.Now().Add(-ICMP_PENDING_TTL)
+	for len(session.pending) > 0 && session.pending[0].time.Before(deadline) {
+		session.pending = session.pending[1:]
+	}
+	if len(session.pending) == 0 {
+		return nil, 0, false
+	}
+	pending := session.pending[len(session.pending)-1]
+	session.pending = session.pending[:len(session.pending)-1]
+	return session, pending.seq, true
+}
@@ -0,0 +1,53 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "time"
+import "bytes"
+import "testing"
+
+func TestICMPChecksum(t *testing.T) {
+	// echo request with id 1, seq 1, payload "ab"
+	msg := []byte{8, 0, 0, 0, 0, 1, 0, 1, 'a', 'b'}
+	sum := icmpChecksum(msg)
+	msg[2], msg[3] = byte(sum>>8), byte(sum)
+	if icmpChecksum(msg) != 0 {
+		t.Errorf("Bad checksum: %x", sum)
+	}
+}
+
+// Requires raw socket, run as root (e.g. between network namespaces)
+func TestICMPTransport(t *testing.T) {
+	server, err := New("icmp", true, []byte(`{"server_addr": "127.0.0.1"}`))
+	if err != nil {
+		t.Skipf("Unable to open server: %v", err)
+	}
+	defer server.Close()
+	client, err := New("icmp", false, []byte(`{"server_addr": "127.0.0.1"}`))
+	if err != nil {
+		t.Skipf("Unable to open client: %v", err)
+	}
+	defer client.Close()
+
+	echo := newTestEcho(server, client)
+
+	for i := 0; i < 16; i += 1 {
+		client.Write(bytes.Repeat([]byte{byte(i)}, 100+i))
+	}
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < 16; i += 1 {
+		select {
+		case data := <-echo.received:
+			if !bytes.Equal(data, bytes.Repeat([]byte{byte(i)}, 100+i)) {
+				t.Fatalf("Bad echo: %v", data)
+			}
+		case <-timeout:
+			t.Fatalf("Timeout waiting for echo")
+		}
+	}
+}
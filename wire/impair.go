@@ -0,0 +1,329 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "io"
+import "net"
+import "fmt"
+import "sync"
+import "time"
+import "math/rand"
+import "container/heap"
+import "encoding/json"
+import log "github.com/Sirupsen/logrus"
+
+const IMPAIR_DEFAULT_LIMIT = 1000
+const IMPAIR_CHANNEL_BUFFER = 64
+
+type ImpairTransportOptions struct {
+	// Wrapped wire, in the same form as wires in config
+	Wire struct {
+		Name    string          `json:"name"`
+		Options json.RawMessage `json:"options"`
+	} `json:"wire"`
+	// Packets impaired: "send" (default), "recv" or "both"
+	Direction string `json:"direction"`
+
+	// Probabilities (0 to 1) of each packet being dropped, duplicated,
+	// having one bit flipped, or sent without delay (so that it's reordered)
+	Loss      float64 `json:"loss"`
+	Duplicate float64 `json:"duplicate"`
+	Corrupt   float64 `json:"corrupt"`
+	Reorder   float64 `json:"reorder"`
+	// Delay (in milliseconds) of each packet, and max random variation of it
+	Latency int `json:"latency"`
+	Jitter  int `json:"jitter"`
+	// Max kilobits per second, packets are queued to fit in it. Default to unlimited
+	Bandwidth float64 `json:"bandwidth"`
+	// Max packets queued, more are dropped
+	Limit int `json:"limit"`
+	// Seed of random decisions, default to current time
+	Seed int64 `json:"seed"`
+}
+
+type impairPacket struct {
+	at   time.Time
+	seq  uint64 // keeps order of packets due at the same time
+	data []byte
+}
+
+// Packets ordered by time to deliver
+type impairHeap []impairPacket
+
+func (h impairHeap) Len() int { return len(h) }
+func (h impairHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h impairHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *impairHeap) Push(x interface{}) { *h = append(*h, x.(impairPacket)) }
+func (h *impairHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Packets in one direction, delivered when they are due
+type impairQueue struct {
+	trans   *ImpairTransport
+	deliver func([]byte)
+
+	lock      sync.Mutex
+	packets   impairHeap
+	next_seq  uint64
+	next_free time.Time // when bandwidth is available again
+	wake      chan struct{}
+}
+
+func newImpairQueue(trans *ImpairTransport, deliver func([]byte)) *impairQueue {
+	q := &impairQueue{
+		trans:   trans,
+		deliver: deliver,
+		wake:    make(chan struct{}, 1),
+	}
+	go q.run()
+	return q
+}
+
+// Impair packet and queue it (or not)
+func (q *impairQueue) Push(data []byte, now time.Time) {
+	opt := &q.trans.opt
+	t := q.trans
+
+	t.rand_lock.Lock()
+	lost := t.rand.Float64() < opt.Loss
+	copies := 1
+	if t.rand.Float64() < opt.Duplicate {
+		copies = 2
+	}
+	var delays []time.Duration
+	var corrupts []int
+	for i := 0; i < copies; i += 1 {
+		delay := time.Duration(opt.Latency) * time.Millisecond
+		if opt.Jitter > 0 {
+			delay += time.Duration((t.rand.Float64()*2 - 1) * float64(opt.Jitter) * float64(time.Millisecond))
+		}
+		if t.rand.Float64() < opt.Reorder || delay < 0 {
+			delay = 0
+		}
+		delays = append(delays, delay)
+		corrupt := -1
+		if len(data) > 0 && t.rand.Float64() < opt.Corrupt {
+			corrupt = t.rand.Intn(len(data) * 8)
+		}
+		corrupts = append(corrupts, corrupt)
+	}
+	t.rand_lock.Unlock()
+	if lost {
+		return
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for i := 0; i < copies; i += 1 {
+		if q.packets.Len() >= t.limit {
+			t.logger.Debug("Queue full, drop packet")
+			return
+		}
+		// sent one by one, the delay starts after it's sent
+		sent := now
+		if opt.Bandwidth > 0 {
+			if q.next_free.After(sent) {
+				sent = q.next_free
+			}
+			sent = sent.Add(time.Duration(float64(len(data)*8) / (opt.Bandwidth * 1000) * float64(time.Second)))
+			q.next_free = sent
+		}
+		packet := make([]byte, len(data))
+		copy(packet, data)
+		if corrupts[i] >= 0 {
+			packet[corrupts[i]/8] ^= 1 << uint(corrupts[i]%8)
+		}
+		heap.Push(&q.packets, impairPacket{sent.Add(delays[i]), q.next_seq, packet})
+		q.next_seq += 1
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *impairQueue) run() {
+	for {
+		q.lock.Lock()
+		var wait <-chan time.Time
+		var due []byte
+		if q.packets.Len() > 0 {
+			if delay := q.packets[0].at.Sub(time.Now()); delay > 0 {
+				wait = time.After(delay)
+			} else {
+				due = heap.Pop(&q.packets).(impairPacket).data
+			}
+		}
+		q.lock.Unlock()
+
+		if due != nil {
+			q.deliver(due)
+			continue
+		}
+		select {
+		case <-q.trans.done:
+			return
+		case <-q.wake:
+		case <-wait:
+		}
+	}
+}
+
+// Wraps another wire, injecting loss, latency, jitter, reordering, duplication,
+// corruption and bandwidth limit like netem, but without root.
+// Useful for testing how others behave on bad networks
+type ImpairTransport struct {
+	inner Transport
+	opt   ImpairTransportOptions
+	limit int
+
+	send_queue *impairQueue
+	recv_queue *impairQueue // nil if received packets are not impaired
+	recv_buf   chan []byte
+
+	rand      *rand.Rand
+	rand_lock sync.Mutex
+
+	done      chan struct{}
+	done_once sync.Once
+
+	logger *log.Entry
+}
+
+func (trans *ImpairTransport) String() string {
+	return fmt.Sprintf("Impair[%v]", trans.inner)
+}
+
+func (trans *ImpairTransport) Open(is_server bool, options json.RawMessage) error {
+	trans.logger = log.WithField("logger", "ImpairTransport")
+
+	if err := json.Unmarshal(options, &trans.opt); err != nil {
+		return err
+	}
+	send, recv := true, false
+	switch trans.opt.Direction {
+	case "", "send":
+	case "recv":
+		send, recv = false, true
+	case "both":
+		recv = true
+	default:
+		return fmt.Errorf("Bad direction: %v", trans.opt.Direction)
+	}
+	trans.limit = IMPAIR_DEFAULT_LIMIT
+	if trans.opt.Limit > 0 {
+		trans.limit = trans.opt.Limit
+	}
+	seed := trans.opt.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	trans.rand = rand.New(rand.NewSource(seed))
+
+	var err error
+	if trans.inner, err = New(trans.opt.Wire.Name, is_server, trans.opt.Wire.Options); err != nil {
+		if trans.inner != nil {
+			trans.inner.Close()
+		}
+		return err
+	}
+	trans.done = make(chan struct{})
+
+	if send {
+		trans.send_queue = newImpairQueue(trans, func(data []byte) {
+			if _, err := trans.inner.Write(data); err != nil {
+				trans.logger.WithField("error", err).Warning("Error writing to wrapped wire")
+			}
+		})
+	}
+	if recv {
+		trans.recv_buf = make(chan []byte, IMPAIR_CHANNEL_BUFFER)
+		trans.recv_queue = newImpairQueue(trans, func(data []byte) {
+			select {
+			case <-trans.done:
+			case trans.recv_buf <- data:
+			}
+		})
+		go trans.receive()
+	}
+	trans.logger.WithFields(log.Fields{
+		"wire":      trans.inner,
+		"direction": trans.opt.Direction,
+		"loss":      trans.opt.Loss,
+		"latency":   trans.opt.Latency,
+		"bandwidth": trans.opt.Bandwidth,
+	}).Info("Impairing wire")
+	return nil
+}
+
+// Read from wrapped wire and impair them
+func (trans *ImpairTransport) receive() {
+	buf := make([]byte, trans.inner.MTU())
+	for {
+		rdlen, err := trans.inner.Read(buf)
+		if err != nil {
+			select {
+			case <-trans.done:
+			default:
+				trans.logger.WithField("error", err).Warning("Error reading from wrapped wire")
+				trans.Close()
+			}
+			return
+		}
+		trans.recv_queue.Push(buf[:rdlen], time.Now())
+	}
+}
+
+func (trans *ImpairTransport) MTU() int {
+	return trans.inner.MTU()
+}
+
+func (trans *ImpairTransport) GetWireNetworks() []net.IPNet {
+	return trans.inner.GetWireNetworks()
+}
+
+func (trans *ImpairTransport) Close() error {
+	if trans.done == nil {
+		return nil
+	}
+	var err error
+	trans.done_once.Do(func() {
+		close(trans.done)
+		err = trans.inner.Close()
+	})
+	return err
+}
+
+func (trans *ImpairTransport) Write(buf []byte) (int, error) {
+	if trans.send_queue == nil {
+		return trans.inner.Write(buf)
+	}
+	trans.send_queue.Push(buf, time.Now())
+	return len(buf), nil
+}
+
+func (trans *ImpairTransport) Read(buf []byte) (int, error) {
+	if trans.recv_queue == nil {
+		return trans.inner.Read(buf)
+	}
+	select {
+	case <-trans.done:
+		return 0, io.EOF
+	case data := <-trans.recv_buf:
+		return copy(buf, data), nil
+	}
+}
@@ -0,0 +1,172 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "fmt"
+import "time"
+import "bytes"
+import "testing"
+
+// Impaired client and plain server joined by loopback
+func testImpairPair(t *testing.T, name, impair string) (Transport, Transport) {
+	loopback := fmt.Sprintf(`{"name": "%v"}`, name)
+	server, err := New("loopback", true, []byte(loopback))
+	if err != nil {
+		t.Fatalf("Unable to open server: %v", err)
+	}
+	client, err := New("impair", false, []byte(fmt.Sprintf(
+		`{"wire": {"name": "loopback", "options": %v}, %v}`, loopback, impair)))
+	if err != nil {
+		server.Close()
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	return server, client
+}
+
+// Read packets until timeout
+func testImpairReadAll(trans Transport, timeout time.Duration) [][]byte {
+	received := make(chan []byte, 256)
+	go func() {
+		for {
+			buf := make([]byte, trans.MTU())
+			rdlen, err := trans.Read(buf)
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- buf[:rdlen]
+		}
+	}()
+	var ret [][]byte
+	deadline := time.After(timeout)
+	for {
+		select {
+		case data := <-received:
+			ret = append(ret, data)
+		case <-deadline:
+			return ret
+		}
+	}
+}
+
+func TestImpairTransportLoss(t *testing.T) {
+	server, client := testImpairPair(t, "TestImpairTransportLoss", `"loss": 0.3, "seed": 42`)
+	defer server.Close()
+	defer client.Close()
+
+	for i := 0; i < 100; i += 1 {
+		client.Write([]byte{byte(i)})
+	}
+	packets := testImpairReadAll(server, 200*time.Millisecond)
+	if len(packets) < 50 || len(packets) > 90 {
+		t.Errorf("About 70 packets should be received: %v", len(packets))
+	}
+	for i := 1; i < len(packets); i += 1 {
+		if packets[i][0] <= packets[i-1][0] {
+			t.Errorf("Packets should be in order")
+		}
+	}
+}
+
+func TestImpairTransportLatency(t *testing.T) {
+	server, client := testImpairPair(t, "TestImpairTransportLatency", `"latency": 100, "jitter": 20`)
+	defer server.Close()
+	defer client.Close()
+
+	start := time.Now()
+	client.Write([]byte("hello"))
+	buf := make([]byte, server.MTU())
+	if rdlen, err := server.Read(buf); err != nil || string(buf[:rdlen]) != "hello" {
+		t.Fatalf("Bad packet: %v %v", buf[:rdlen], err)
+	}
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Bad latency: %v", elapsed)
+	}
+}
+
+func TestImpairTransportReorder(t *testing.T) {
+	server, client := testImpairPair(t, "TestImpairTransportReorder",
+		`"latency": 50, "reorder": 0.3, "duplicate": 0.2, "seed": 42`)
+	defer server.Close()
+	defer client.Close()
+
+	for i := 0; i < 50; i += 1 {
+		client.Write([]byte{byte(i)})
+	}
+	packets := testImpairReadAll(server, 300*time.Millisecond)
+	if len(packets) <= 50 {
+		t.Errorf("Some packets should be duplicated: %v", len(packets))
+	}
+	reordered := 0
+	for i := 1; i < len(packets); i += 1 {
+		if packets[i][0] < packets[i-1][0] {
+			reordered += 1
+		}
+	}
+	if reordered == 0 {
+		t.Errorf("Some packets should be reordered")
+	}
+}
+
+func TestImpairTransportCorrupt(t *testing.T) {
+	server, client := testImpairPair(t, "TestImpairTransportCorrupt",
+		`"corrupt": 1, "direction": "both"`)
+	defer server.Close()
+	defer client.Close()
+
+	sent := bytes.Repeat([]byte{0x55}, 100)
+	client.Write(sent)
+	buf := make([]byte, server.MTU())
+	rdlen, _ := server.Read(buf)
+	diff := 0
+	for i := 0; i < rdlen; i += 1 {
+		for b := buf[i] ^ sent[i]; b != 0; b &= b - 1 {
+			diff += 1
+		}
+	}
+	if rdlen != len(sent) || diff != 1 {
+		t.Errorf("One bit should be flipped: %v bits in %v bytes", diff, rdlen)
+	}
+
+	// received packets are impaired too
+	server.Write(sent)
+	if rdlen, _ = client.Read(buf); bytes.Equal(buf[:rdlen], sent) {
+		t.Errorf("Received packet should be corrupted")
+	}
+}
+
+func TestImpairTransportBandwidth(t *testing.T) {
+	// 1000 bytes per 100ms
+	server, client := testImpairPair(t, "TestImpairTransportBandwidth", `"bandwidth": 80, "limit": 5`)
+	defer server.Close()
+	defer client.Close()
+
+	start := time.Now()
+	for i := 0; i < 10; i += 1 {
+		client.Write(make([]byte, 1000))
+	}
+	buf := make([]byte, server.MTU())
+	for i := 0; i < 5; i += 1 {
+		server.Read(buf)
+	}
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Should take about 500ms: %v", elapsed)
+	}
+	if packets := testImpairReadAll(server, 200*time.Millisecond); len(packets) != 0 {
+		t.Errorf("Packets exceeding limit should be dropped: %v more", len(packets))
+	}
+}
+
+func TestImpairTransportBadOptions(t *testing.T) {
+	if _, err := New("impair", false, []byte(`{"wire": {"name": "nonexist"}}`)); err == nil {
+		t.Errorf("Bad wrapped wire should fail")
+	}
+	if _, err := New("impair", false, []byte(`{"wire": {"name": "loopback", "options": {}}, "direction": "up"}`)); err == nil {
+		t.Errorf("Bad direction should fail")
+	}
+}
@@ -0,0 +1,152 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "io"
+import "net"
+import "fmt"
+import "sync"
+import "encoding/json"
+import log "github.com/Sirupsen/logrus"
+
+const LOOPBACK_DEFAULT_MTU = 1500
+const LOOPBACK_CHANNEL_BUFFER = 64
+
+type LoopbackTransportOptions struct {
+	// Server and client with the same name are joined
+	Name string  `json:"name"`
+	MTU  float64 `json:"mtu"`
+}
+
+// Queues between server and client of the same name,
+// created by whichever is opened first
+type loopbackPair struct {
+	to_server chan []byte
+	to_client chan []byte
+	opened    [2]bool // by server, by client
+}
+
+var loopback_lock sync.Mutex
+var loopback_pairs = make(map[string]*loopbackPair)
+
+func loopbackSide(is_server bool) int {
+	if is_server {
+		return 0
+	}
+	return 1
+}
+
+// In-process transport for testing, without any socket.
+// Packets are never dropped or reordered: writing blocks while the queue is full,
+// packets written before the other end is opened are queued too.
+type LoopbackTransport struct {
+	name      string
+	is_server bool
+	mtu       int
+
+	pair      *loopbackPair
+	send_buf  chan []byte
+	recv_buf  chan []byte
+	done      chan struct{}
+	done_once sync.Once
+
+	logger *log.Entry
+}
+
+func (trans *LoopbackTransport) String() string {
+	return fmt.Sprintf("Loopback[%v]", trans.name)
+}
+
+func (trans *LoopbackTransport) MTU() int {
+	return trans.mtu
+}
+
+func (trans *LoopbackTransport) Open(is_server bool, options json.RawMessage) error {
+	trans.logger = log.WithField("logger", "LoopbackTransport")
+
+	var opt LoopbackTransportOptions
+	if err := json.Unmarshal(options, &opt); err != nil {
+		return err
+	}
+	trans.name = opt.Name
+	trans.is_server = is_server
+	trans.mtu = LOOPBACK_DEFAULT_MTU
+	if opt.MTU > 0 {
+		trans.mtu = int(opt.MTU)
+	}
+
+	loopback_lock.Lock()
+	defer loopback_lock.Unlock()
+	pair := loopback_pairs[opt.Name]
+	if pair == nil {
+		pair = &loopbackPair{
+			to_server: make(chan []byte, LOOPBACK_CHANNEL_BUFFER),
+			to_client: make(chan []byte, LOOPBACK_CHANNEL_BUFFER),
+		}
+		loopback_pairs[opt.Name] = pair
+	}
+	side := loopbackSide(is_server)
+	if pair.opened[side] {
+		return fmt.Errorf("Loopback %q is already opened by the same side", opt.Name)
+	}
+	pair.opened[side] = true
+
+	trans.pair = pair
+	trans.send_buf, trans.recv_buf = pair.to_client, pair.to_server
+	if !is_server {
+		trans.send_buf, trans.recv_buf = pair.to_server, pair.to_client
+	}
+	trans.done = make(chan struct{})
+	trans.logger.WithFields(log.Fields{
+		"name":      opt.Name,
+		"is_server": is_server,
+	}).Debug("Loopback opened")
+	return nil
+}
+
+// Not routed to anywhere
+func (trans *LoopbackTransport) GetWireNetworks() []net.IPNet {
+	return make([]net.IPNet, 0)
+}
+
+// The name can be used again once both ends are closed
+func (trans *LoopbackTransport) Close() error {
+	if trans.done == nil {
+		return nil
+	}
+	trans.done_once.Do(func() {
+		close(trans.done)
+		loopback_lock.Lock()
+		defer loopback_lock.Unlock()
+		trans.pair.opened[loopbackSide(trans.is_server)] = false
+		if !trans.pair.opened[0] && !trans.pair.opened[1] && loopback_pairs[trans.name] == trans.pair {
+			delete(loopback_pairs, trans.name)
+		}
+	})
+	return nil
+}
+
+func (trans *LoopbackTransport) Write(buf []byte) (int, error) {
+	packet := make([]byte, len(buf))
+	copy(packet, buf)
+	select {
+	case <-trans.done:
+		return 0, io.EOF
+	case trans.send_buf <- packet:
+		return len(buf), nil
+	}
+}
+
+func (trans *LoopbackTransport) Read(buf []byte) (int, error) {
+	select {
+	case <-trans.done:
+		return 0, io.EOF
+	case data := <-trans.recv_buf:
+		return copy(buf, data), nil
+	}
+}
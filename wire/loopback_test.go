@@ -0,0 +1,74 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "io"
+import "bytes"
+import "testing"
+
+func TestLoopbackTransport(t *testing.T) {
+	options := []byte(`{"name": "TestLoopbackTransport", "mtu": 1000}`)
+	// client first, packets are queued until server is opened
+	client, err := New("loopback", false, options)
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	for i := 0; i < 10; i += 1 {
+		client.Write([]byte{byte(i)})
+	}
+	server, err := New("loopback", true, options)
+	if err != nil {
+		t.Fatalf("Unable to open server: %v", err)
+	}
+	if client.MTU() != 1000 || len(server.GetWireNetworks()) != 0 {
+		t.Errorf("Bad MTU or wire networks: %v %v", client.MTU(), server.GetWireNetworks())
+	}
+	if _, err := New("loopback", true, options); err == nil {
+		t.Errorf("Opening the same side twice should fail")
+	}
+
+	buf := make([]byte, server.MTU())
+	for i := 0; i < 10; i += 1 {
+		if rdlen, err := server.Read(buf); err != nil || !bytes.Equal(buf[:rdlen], []byte{byte(i)}) {
+			t.Fatalf("Bad packet %v: %v %v", i, buf[:rdlen], err)
+		}
+	}
+	server.Write([]byte("hello"))
+	if rdlen, err := client.Read(buf); err != nil || string(buf[:rdlen]) != "hello" {
+		t.Errorf("Bad packet from server: %v %v", buf[:rdlen], err)
+	}
+
+	client.Close()
+	server.Close()
+	if _, err := client.Read(buf); err != io.EOF {
+		t.Errorf("Read after close should return EOF: %v", err)
+	}
+
+	// the name is free again
+	server, err = New("loopback", true, options)
+	if err != nil {
+		t.Fatalf("Unable to open server again: %v", err)
+	}
+	server.Close()
+}
+
+func TestLoopbackTransportNames(t *testing.T) {
+	a, _ := New("loopback", true, []byte(`{"name": "TestLoopbackTransportNames-a"}`))
+	defer a.Close()
+	b, _ := New("loopback", false, []byte(`{"name": "TestLoopbackTransportNames-b"}`))
+	defer b.Close()
+	a_client, _ := New("loopback", false, []byte(`{"name": "TestLoopbackTransportNames-a"}`))
+	defer a_client.Close()
+
+	b.Write([]byte("to b"))
+	a_client.Write([]byte("to a"))
+	buf := make([]byte, a.MTU())
+	if rdlen, err := a.Read(buf); err != nil || string(buf[:rdlen]) != "to a" {
+		t.Errorf("Packet should not cross names: %v %v", string(buf[:rdlen]), err)
+	}
+}
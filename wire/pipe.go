@@ -0,0 +1,225 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "os"
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "os/exec"
+import "encoding/json"
+import log "github.com/Sirupsen/logrus"
+
+type PipeTransportOptions struct {
+	MTU float64 `json:"mtu"`
+	// Command run by shell, packets go through its stdin and stdout,
+	// e.g. "ssh host justvpn -s --stdio config.json".
+	// Default to use stdin and stdout of ourselves
+	Command string `json:"command"`
+}
+
+// Closed when stdin is closed, if it's used by pipe transport
+var PipeStdioClosed = make(chan struct{})
+var pipe_stdio_closed_once sync.Once
+
+var pipe_stdio_lock sync.Mutex
+var pipe_stdio_used bool
+
+type pipeAddr string
+
+func (addr pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (addr pipeAddr) String() string {
+	return string(addr)
+}
+
+// Stream over a pair of pipes, so that it can be framed like TCP.
+// The command (if any) is killed when closed.
+// Stdio of ourselves is kept open to be used again, only reading is interrupted
+type pipeConn struct {
+	reader *os.File
+	writer *os.File
+	cmd    *exec.Cmd
+	name   string
+	stdio  bool
+
+	close_once sync.Once
+}
+
+func (conn *pipeConn) Read(buf []byte) (int, error) {
+	return conn.reader.Read(buf)
+}
+
+func (conn *pipeConn) Write(buf []byte) (int, error) {
+	return conn.writer.Write(buf)
+}
+
+func (conn *pipeConn) Close() error {
+	conn.close_once.Do(func() {
+		if conn.stdio {
+			// not supported if stdin is not a pipe, e.g. regular file
+			conn.reader.SetReadDeadline(time.Now())
+			return
+		}
+		conn.reader.Close()
+		conn.writer.Close()
+		if conn.cmd != nil {
+			conn.cmd.Process.Kill()
+			conn.cmd.Wait()
+		}
+	})
+	return nil
+}
+
+func (conn *pipeConn) LocalAddr() net.Addr {
+	return pipeAddr("local")
+}
+
+func (conn *pipeConn) RemoteAddr() net.Addr {
+	return pipeAddr(conn.name)
+}
+
+func (conn *pipeConn) SetDeadline(t time.Time) error {
+	conn.SetReadDeadline(t)
+	return conn.SetWriteDeadline(t)
+}
+
+func (conn *pipeConn) SetReadDeadline(t time.Time) error {
+	return conn.reader.SetReadDeadline(t)
+}
+
+// Not supported if stdout is a regular file, which never blocks anyway
+func (conn *pipeConn) SetWriteDeadline(t time.Time) error {
+	return conn.writer.SetWriteDeadline(t)
+}
+
+// Start command with its stdin and stdout connected to us, stderr is passed through
+func startPipeCommand(command string) (*pipeConn, error) {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	stdin, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	reader, stdout, err := os.Pipe()
+	if err != nil {
+		stdin.Close()
+		writer.Close()
+		return nil, err
+	}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	err = cmd.Start()
+	// ends of child, or EOF would never be seen
+	stdin.Close()
+	stdout.Close()
+	if err != nil {
+		reader.Close()
+		writer.Close()
+		return nil, fmt.Errorf("Error starting command: %v", err)
+	}
+	return &pipeConn{reader: reader, writer: writer, cmd: cmd, name: command}, nil
+}
+
+// Packets over stdin and stdout of a command (like ProxyCommand of OpenSSH),
+// or of ourselves when started by the command of the other side.
+// The command is started again whenever it exits.
+// Routes to the remote host (if any) should be set by "route" of config.
+type PipeTransport struct {
+	TCPTransport
+	command string
+	stdio   bool
+}
+
+func (trans *PipeTransport) String() string {
+	if len(trans.command) == 0 {
+		return "Pipe[stdio]"
+	}
+	return fmt.Sprintf("Pipe[%v]", trans.command)
+}
+
+func (trans *PipeTransport) Open(is_server bool, options json.RawMessage) error {
+	trans.logger = log.WithField("logger", "PipeTransport")
+
+	var opt PipeTransportOptions
+	if err := json.Unmarshal(options, &opt); err != nil {
+		return err
+	}
+	if len(opt.Command) == 0 {
+		return trans.openStdio(opt)
+	}
+
+	trans.command = opt.Command
+	trans.logger.WithField("command", opt.Command).Info("Running command")
+	return trans.openFramed("Pipe", false, nil, TCPTransportOptions{MTU: opt.MTU},
+		func() (FrameConn, error) {
+			conn, err := startPipeCommand(opt.Command)
+			if err != nil {
+				return nil, err
+			}
+			return &FramedConn{Conn: conn}, nil
+		}, nil)
+}
+
+// Use stdin and stdout of ourselves even if command is set,
+// so that the same options can be used on both sides
+func (trans *PipeTransport) OpenStdio(is_server bool, options json.RawMessage) error {
+	trans.logger = log.WithField("logger", "PipeTransport")
+
+	var opt PipeTransportOptions
+	if err := json.Unmarshal(options, &opt); err != nil {
+		return err
+	}
+	return trans.openStdio(opt)
+}
+
+func (trans *PipeTransport) openStdio(opt PipeTransportOptions) error {
+	pipe_stdio_lock.Lock()
+	defer pipe_stdio_lock.Unlock()
+	if pipe_stdio_used {
+		return fmt.Errorf("Stdio is already used by another pipe transport")
+	}
+
+	// single connection, served like the server side
+	if err := trans.openFramed("Pipe", true, nil, TCPTransportOptions{MTU: opt.MTU}, nil, nil); err != nil {
+		return err
+	}
+	pipe_stdio_used = true
+	trans.stdio = true
+	trans.logger.Info("Using stdin and stdout")
+	// reading may be interrupted by previous transport
+	os.Stdin.SetReadDeadline(time.Time{})
+	conn := &pipeConn{reader: os.Stdin, writer: os.Stdout, name: "stdio", stdio: true}
+	go func() {
+		trans.serveConn(&FramedConn{Conn: conn})
+		select {
+		case <-trans.done:
+		default:
+			pipe_stdio_closed_once.Do(func() { close(PipeStdioClosed) })
+		}
+	}()
+	return nil
+}
+
+func (trans *PipeTransport) Close() error {
+	err := trans.TCPTransport.Close()
+	if trans.stdio {
+		pipe_stdio_lock.Lock()
+		pipe_stdio_used = false
+		pipe_stdio_lock.Unlock()
+	}
+	return err
+}
+
+// Host of command is unknown, let user route it
+func (trans *PipeTransport) GetWireNetworks() []net.IPNet {
+	return make([]net.IPNet, 0)
+}
@@ -0,0 +1,63 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "bytes"
+import "testing"
+
+func TestPipeTransport(t *testing.T) {
+	// cat echoes framed packets back
+	trans, err := New("pipe", false, []byte(`{"command": "exec cat", "mtu": 1000}`))
+	if err != nil {
+		t.Fatalf("Unable to open: %v", err)
+	}
+	defer trans.Close()
+	if trans.MTU() != 1000 || len(trans.GetWireNetworks()) != 0 {
+		t.Errorf("Bad MTU or wire networks: %v %v", trans.MTU(), trans.GetWireNetworks())
+	}
+
+	// packets written before command is started are dropped
+	echo := testTransportEcho(t, nil, trans,
+		[]byte("hello"), bytes.Repeat([]byte("justvpn"), trans.MTU()/7))
+
+	// command exits, it should be started again
+	pipe := trans.(*PipeTransport)
+	pipe.conn_lock.Lock()
+	pipe.conn.(*FramedConn).Conn.(*pipeConn).cmd.Process.Kill()
+	pipe.conn_lock.Unlock()
+	echo.EchoAll(t, []byte("restarted"))
+}
+
+func TestPipeTransportBadCommand(t *testing.T) {
+	trans, err := New("pipe", false, []byte(`{"command": "exit 1"}`))
+	if err != nil {
+		t.Fatalf("Open should not fail before command is run: %v", err)
+	}
+	defer trans.Close()
+	// dropped silently
+	if n, err := trans.Write([]byte("hello")); n != 5 || err != nil {
+		t.Errorf("Bad write: %v %v", n, err)
+	}
+}
+
+func TestPipeTransportStdio(t *testing.T) {
+	trans, err := New("pipe", false, []byte(`{}`))
+	if err != nil {
+		t.Fatalf("Unable to open: %v", err)
+	}
+	if _, err := New("pipe", false, []byte(`{}`)); err == nil {
+		t.Errorf("Stdio should not be used twice")
+	}
+	trans.Close()
+	// can be used again once closed
+	trans, err = New("pipe", false, []byte(`{}`))
+	if err != nil {
+		t.Fatalf("Unable to open again: %v", err)
+	}
+	trans.Close()
+}
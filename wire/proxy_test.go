@@ -0,0 +1,291 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "io"
+import "os"
+import "fmt"
+import "net"
+import "time"
+import "bytes"
+import "bufio"
+import "testing"
+import "net/http"
+import "io/ioutil"
+import "encoding/base64"
+
+// Minimal SOCKS5 proxy with username/password auth, supports CONNECT and UDP ASSOCIATE
+func startTestSOCKS5(t *testing.T, user, password string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("Unable to listen on 127.0.0.2: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSOCKS5(conn, user, password)
+		}
+	}()
+	return listener
+}
+
+func serveTestSOCKS5(conn net.Conn, user, password string) {
+	defer conn.Close()
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	method := byte(0xff)
+	for _, m := range methods {
+		if m == SOCKS5_AUTH_PASSWORD {
+			method = m
+		}
+	}
+	conn.Write([]byte{SOCKS5_VERSION, method})
+	if method == 0xff {
+		return
+	}
+	// ver, ulen, user, plen, password
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return
+	}
+	name := make([]byte, header[1])
+	io.ReadFull(conn, name)
+	io.ReadFull(conn, header[:1])
+	passwd := make([]byte, header[0])
+	io.ReadFull(conn, passwd)
+	if string(name) != user || string(passwd) != password {
+		conn.Write([]byte{SOCKS5_AUTH_PASSWORD_VERSION, 1})
+		return
+	}
+	conn.Write([]byte{SOCKS5_AUTH_PASSWORD_VERSION, 0})
+
+	var request [3]byte
+	if _, err := io.ReadFull(conn, request[:]); err != nil {
+		return
+	}
+	addr, err := readSOCKS5Addr(conn)
+	if err != nil {
+		return
+	}
+	reply := func(code byte, port int) {
+		conn.Write(append([]byte{SOCKS5_VERSION, code, 0}, socks5Addr(net.IPv4zero, "", port)...))
+	}
+
+	switch request[1] {
+	case SOCKS5_CMD_CONNECT:
+		remote, err := net.Dial("tcp", addr)
+		if err != nil {
+			reply(5, 0)
+			return
+		}
+		defer remote.Close()
+		reply(0, 0)
+		go io.Copy(remote, conn)
+		io.Copy(conn, remote)
+	case SOCKS5_CMD_UDP_ASSOCIATE:
+		relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.2")})
+		if err != nil {
+			reply(1, 0)
+			return
+		}
+		defer relay.Close()
+		// reply unspecified address, client should use address of proxy
+		reply(0, relay.LocalAddr().(*net.UDPAddr).Port)
+		go func() {
+			var client *net.UDPAddr
+			buf := make([]byte, UDP_MAX_PACKET_SIZE)
+			for {
+				rdlen, from, err := relay.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				if from.IP.Equal(conn.RemoteAddr().(*net.TCPAddr).IP) && (client == nil || from.Port == client.Port) {
+					data, to, err := socks5UDPUnwrap(buf[:rdlen])
+					if err != nil {
+						continue
+					}
+					client = from
+					to_addr, _ := net.ResolveUDPAddr("udp", to)
+					relay.WriteToUDP(data, to_addr)
+				} else if client != nil {
+					relay.WriteToUDP(socks5UDPWrap(buf[:rdlen], from), client)
+				}
+			}
+		}()
+		io.Copy(ioutil.Discard, conn)
+	default:
+		reply(7, 0)
+	}
+}
+
+// Minimal HTTP CONNECT proxy with basic auth
+func startTestHTTPProxy(t *testing.T, user, password string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("Unable to listen on 127.0.0.2: %v", err)
+	}
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if req.Method != "CONNECT" || req.Header.Get("Proxy-Authorization") != auth {
+					conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+					return
+				}
+				remote, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer remote.Close()
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go io.Copy(remote, conn)
+				io.Copy(conn, remote)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestProxyDialerAuth(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	socks := startTestSOCKS5(t, "user", "pass")
+	defer socks.Close()
+	http_proxy := startTestHTTPProxy(t, "user", "pass")
+	defer http_proxy.Close()
+
+	for _, c := range []struct {
+		proxy string
+		ok    bool
+	}{
+		{"socks5://user:pass@" + socks.Addr().String(), true},
+		{"socks5://user:wrong@" + socks.Addr().String(), false},
+		{"socks5://" + socks.Addr().String(), false},
+		{"http://user:pass@" + http_proxy.Addr().String(), true},
+		{"http://user:wrong@" + http_proxy.Addr().String(), false},
+	} {
+		dialer, err := NewProxyDialer(c.proxy, &net.Dialer{Timeout: time.Second})
+		if err != nil {
+			t.Fatalf("Unable to create dialer: %v", err)
+		}
+		conn, err := dialer.Dial(echo.Addr().String())
+		if (err == nil) != c.ok {
+			t.Errorf("Dial via %v: %v", c.proxy, err)
+		}
+		if err != nil {
+			continue
+		}
+		conn.Write([]byte("justvpn"))
+		buf := make([]byte, 7)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "justvpn" {
+			t.Errorf("Bad echo via %v: %v", c.proxy, err)
+		}
+		conn.Close()
+	}
+
+	if _, err := NewProxyDialer("ftp://"+socks.Addr().String(), &net.Dialer{}); err == nil {
+		t.Errorf("Unsupported proxy should fail")
+	}
+}
+
+func TestTCPTransportSOCKS5(t *testing.T) {
+	socks := startTestSOCKS5(t, "user", "pass")
+	defer socks.Close()
+
+	server, err := New("tcp", true, []byte(`{"server_addr": "127.0.0.1:53651"}`))
+	if err != nil {
+		t.Fatalf("Unable to open server: %v", err)
+	}
+	defer server.Close()
+	client, err := New("tcp", false, []byte(fmt.Sprintf(
+		`{"server_addr": "127.0.0.1:53651", "proxy": "socks5://user:pass@%v"}`, socks.Addr())))
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	defer client.Close()
+
+	if nets := client.GetWireNetworks(); len(nets) != 1 || nets[0].String() != "127.0.0.2/32" {
+		t.Errorf("Bad wire networks: %v", nets)
+	}
+	testTransportEcho(t, server, client,
+		[]byte("hello"), bytes.Repeat([]byte("justvpn"), client.MTU()/7))
+}
+
+func TestTLSTransportHTTPProxy(t *testing.T) {
+	proxy := startTestHTTPProxy(t, "user", "pass")
+	defer proxy.Close()
+	dir, err := ioutil.TempDir("", "justvpn-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cert, key, _ := writeTestCert(t, dir)
+
+	server_options := fmt.Sprintf(`{"server_addr": "127.0.0.1:53652", "cert": "%v", "key": "%v"}`,
+		cert, key)
+	client_options := fmt.Sprintf(`{"server_addr": "127.0.0.1:53652", "ca": "%v",
+	                                "sni": "vpn.example.com", "proxy": "http://user:pass@%v"}`,
+		cert, proxy.Addr())
+	if !testTLSEcho(t, server_options, client_options) {
+		t.Errorf("Timeout waiting for echo")
+	}
+}
+
+func TestUDPTransportSOCKS5(t *testing.T) {
+	socks := startTestSOCKS5(t, "user", "pass")
+	defer socks.Close()
+
+	client_options := fmt.Sprintf(`{"server_addr": "127.0.0.1:53653", "proxy": "socks5://user:pass@%v"}`,
+		socks.Addr())
+	client, err := New("udp", false, []byte(client_options))
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	nets := client.GetWireNetworks()
+	client.Close()
+	if len(nets) != 1 || nets[0].String() != "127.0.0.2/32" {
+		t.Errorf("Bad wire networks: %v", nets)
+	}
+
+	testUDPEcho(t, `{"server_addr": "127.0.0.1:53653"}`, client_options, 200*time.Millisecond)
+}
@@ -0,0 +1,49 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "testing"
+import "bytes"
+
+func TestTCPTransport(t *testing.T) {
+	options := []byte(`{"server_addr": "127.0.0.1:53561"}`)
+	server, err := New("tcp", true, options)
+	if err != nil {
+		t.Fatalf("Unable to open server: %v", err)
+	}
+	defer server.Close()
+	client, err := New("tcp", false, options)
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	defer client.Close()
+
+	echo := testTransportEcho(t, server, client,
+		[]byte("hello"), bytes.Repeat([]byte("justvpn"), client.MTU()/7))
+
+	// break the connection, client should reconnect
+	tcp_server := server.(*TCPTransport)
+	tcp_server.conn_lock.Lock()
+	for _, conn := range tcp_server.conns {
+		conn.Close()
+	}
+	tcp_server.conn_lock.Unlock()
+	echo.EchoAll(t, []byte("reconnected"))
+}
+
+func TestTCPTransportWireNetworks(t *testing.T) {
+	client, err := New("tcp", false, []byte(`{"server_addr": "127.0.0.1:53562"}`))
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	defer client.Close()
+	nets := client.GetWireNetworks()
+	if len(nets) != 1 || nets[0].String() != "127.0.0.1/32" {
+		t.Errorf("Bad wire networks: %v", nets)
+	}
+}
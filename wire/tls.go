@@ -0,0 +1,175 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "net"
+import "fmt"
+import "time"
+import "bytes"
+import "io/ioutil"
+import "crypto/tls"
+import "crypto/x509"
+import "crypto/sha256"
+import "encoding/base64"
+import "encoding/json"
+import log "github.com/Sirupsen/logrus"
+
+type TLSTransportOptions struct {
+	TCPTransportOptions
+
+	// Server side, PEM files of certificate (chain) and private key
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+
+	// Client side, PEM file of CA to verify server against, system roots if empty
+	CA string `json:"ca"`
+	// Client side, base64 SHA256 hashes of server's SubjectPublicKeyInfo,
+	// if set, the server must match one of them. Certificate chain is
+	// not verified if CA is not set either (e.g. self-signed certificate)
+	PinSHA256 []string `json:"pin_sha256"`
+	// Client side, server name for SNI and verification, default to host of server_addr
+	SNI string `json:"sni"`
+}
+
+// Offer the same ALPN as browsers, so it looks like HTTPS
+var TLS_ALPN = []string{"h2", "http/1.1"}
+
+// Framed TCP over TLS
+type TLSTransport struct {
+	TCPTransport
+}
+
+func (trans *TLSTransport) Open(is_server bool, options json.RawMessage) error {
+	trans.logger = log.WithField("logger", "TLSTransport")
+
+	var opt TLSTransportOptions
+	if err := json.Unmarshal(options, &opt); err != nil {
+		return err
+	}
+
+	server_addr, err := net.ResolveTCPAddr("tcp", opt.ServerAddr)
+	if err != nil {
+		return fmt.Errorf("Error resolving server addr: %v", err)
+	}
+
+	if is_server {
+		cert, err := tls.LoadX509KeyPair(opt.Cert, opt.Key)
+		if err != nil {
+			return fmt.Errorf("Error loading certificate: %v", err)
+		}
+		config := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   TLS_ALPN,
+			MinVersion:   tls.VersionTLS12,
+		}
+		return trans.openFramed("TLS", is_server, server_addr, opt.TCPTransportOptions, nil,
+			func() (net.Listener, error) {
+				return tls.Listen("tcp", server_addr.String(), config)
+			})
+	}
+
+	config, err := opt.clientConfig()
+	if err != nil {
+		return err
+	}
+	dial, err := trans.newDialer(server_addr, opt.TCPTransportOptions)
+	if err != nil {
+		return err
+	}
+	return trans.openFramed("TLS", is_server, server_addr, opt.TCPTransportOptions,
+		func() (FrameConn, error) {
+			conn, err := dialTLS(dial, config)
+			if err != nil {
+				return nil, err
+			}
+			return &FramedConn{Conn: conn}, nil
+		}, nil)
+}
+
+// Make TLS connection over dialed connection
+func dialTLS(dial func() (net.Conn, error), config *tls.Config) (net.Conn, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	tls_conn := tls.Client(conn, config)
+	tls_conn.SetDeadline(time.Now().Add(TCP_DIAL_TIMEOUT))
+	if err = tls_conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tls_conn.SetDeadline(time.Time{})
+	return tls_conn, nil
+}
+
+// Load PEM certificates to verify server against
+func loadCA(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificate found in CA file %v", path)
+	}
+	return pool, nil
+}
+
+func (opt *TLSTransportOptions) clientConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: opt.SNI,
+		NextProtos: TLS_ALPN,
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(config.ServerName) == 0 {
+		host, _, err := net.SplitHostPort(opt.ServerAddr)
+		if err != nil {
+			return nil, fmt.Errorf("Bad server addr: %v", err)
+		}
+		config.ServerName = host
+	}
+
+	if len(opt.CA) > 0 {
+		var err error
+		if config.RootCAs, err = loadCA(opt.CA); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(opt.PinSHA256) == 0 {
+		return config, nil
+	}
+	var pins [][]byte
+	for _, pin := range opt.PinSHA256 {
+		hash, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("Bad SHA256 pin: %v", pin)
+		}
+		pins = append(pins, hash)
+	}
+	// Pinning alone is enough to authenticate server, skip chain
+	// verification (only for this config) if there's no CA to verify against
+	config.InsecureSkipVerify = len(opt.CA) == 0
+	config.VerifyPeerCertificate = func(raw_certs [][]byte, _ [][]*x509.Certificate) error {
+		if len(raw_certs) == 0 {
+			return fmt.Errorf("No server certificate")
+		}
+		cert, err := x509.ParseCertificate(raw_certs[0])
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(pin, hash[:]) {
+				return nil
+			}
+		}
+		return fmt.Errorf("Server public key does not match any pin")
+	}
+	return config, nil
+}
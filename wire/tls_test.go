@@ -0,0 +1,110 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "os"
+import "fmt"
+import "time"
+import "bytes"
+import "testing"
+import "math/big"
+import "io/ioutil"
+import "path/filepath"
+import "encoding/pem"
+import "encoding/base64"
+import "crypto/rand"
+import "crypto/x509"
+import "crypto/sha256"
+import "crypto/ecdsa"
+import "crypto/elliptic"
+import "crypto/x509/pkix"
+
+// Write self-signed certificate for "vpn.example.com" and its key,
+// return paths of them and SPKI pin
+func writeTestCert(t *testing.T, dir string) (string, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vpn.example.com"},
+		DNSNames:              []string{"vpn.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key_der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert_path := filepath.Join(dir, "cert.pem")
+	key_path := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(cert_path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(key_path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key_der}), 0600)
+
+	cert, _ := x509.ParseCertificate(der)
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return cert_path, key_path, base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Return whether a packet is echoed by server within timeout
+func testTLSEcho(t *testing.T, server_options, client_options string) bool {
+	server, err := New("tls", true, []byte(server_options))
+	if err != nil {
+		t.Fatalf("Unable to open server: %v", err)
+	}
+	defer server.Close()
+	client, err := New("tls", false, []byte(client_options))
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	defer client.Close()
+
+	msg := bytes.Repeat([]byte("justvpn"), client.MTU()/7)
+	return newTestEcho(server, client).Echo(msg, 2*time.Second)
+}
+
+func TestTLSTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "justvpn-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cert, key, pin := writeTestCert(t, dir)
+	other_dir := filepath.Join(dir, "other")
+	os.Mkdir(other_dir, 0700)
+	_, _, other_pin := writeTestCert(t, other_dir)
+
+	server_options := fmt.Sprintf(`{"server_addr": "127.0.0.1:53571", "cert": "%v", "key": "%v"}`,
+		cert, key)
+	for _, c := range []struct {
+		options string
+		ok      bool
+	}{
+		{`"ca": "` + cert + `", "sni": "vpn.example.com"`, true},
+		{`"ca": "` + cert + `", "sni": "other.example.com"`, false},
+		{`"pin_sha256": ["` + other_pin + `", "` + pin + `"]`, true},
+		{`"pin_sha256": ["` + other_pin + `"]`, false},
+		{`"ca": "` + cert + `", "sni": "vpn.example.com", "pin_sha256": ["` + other_pin + `"]`, false},
+		// self-signed certificate is not trusted by system
+		{`"sni": "vpn.example.com"`, false},
+	} {
+		client_options := `{"server_addr": "127.0.0.1:53571", ` + c.options + `}`
+		if ok := testTLSEcho(t, server_options, client_options); ok != c.ok {
+			t.Errorf("Expect %v with options %v, got %v", c.ok, c.options, ok)
+		}
+	}
+}
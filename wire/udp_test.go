@@ -0,0 +1,128 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "net"
+import "time"
+import "bytes"
+import "testing"
+
+func TestUDPPortHopper(t *testing.T) {
+	hopper, err := NewUDPPortHopper("40000-40099", "secret", 1000, 100)
+	if err != nil {
+		t.Fatalf("Unable to create hopper: %v", err)
+	}
+	other, _ := NewUDPPortHopper("40000-40099", "secret", 1000, 100)
+	ports := make(map[int]bool)
+	now := time.Now()
+	for i := 0; i < 100; i += 1 {
+		tm := now.Add(time.Duration(i) * time.Second)
+		port := hopper.Port(tm)
+		if port < 40000 || port > 40099 {
+			t.Fatalf("Port out of range: %v", port)
+		}
+		if port != other.Port(tm) {
+			t.Fatalf("Port mismatch with same secret")
+		}
+		ports[port] = true
+
+		listen := hopper.ListenPorts(tm)
+		if listen[0] != port {
+			t.Errorf("Not listening on current port")
+		}
+	}
+	if len(ports) < 20 {
+		t.Errorf("Ports not hopping enough: %v", len(ports))
+	}
+
+	for _, bad := range []string{"", "abc", "2-1", "1-70000"} {
+		if _, err := NewUDPPortHopper(bad, "secret", 0, 0); err == nil {
+			t.Errorf("Expect error for port range %v", bad)
+		}
+	}
+	if _, err := NewUDPPortHopper("1-2", "", 0, 0); err == nil {
+		t.Errorf("Expect error for empty secret")
+	}
+}
+
+func testUDPEcho(t *testing.T, server_options, client_options string, duration time.Duration) {
+	server, err := New("udp", true, []byte(server_options))
+	if err != nil {
+		t.Fatalf("Unable to open server: %v", err)
+	}
+	defer server.Close()
+	client, err := New("udp", false, []byte(client_options))
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	defer client.Close()
+
+	echo := newTestEcho(server, client)
+	lost := 0
+	for start, i := time.Now(), 0; time.Since(start) < duration; i += 1 {
+		msg := bytes.Repeat([]byte{byte(i)}, 100)
+		client.Write(msg)
+		select {
+		case data := <-echo.received:
+			if bytes.Compare(data, msg) != 0 {
+				t.Errorf("Echoed msg mismatch")
+			}
+		case <-time.After(time.Second):
+			lost += 1
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if lost > 0 {
+		t.Errorf("%v packets lost", lost)
+	}
+}
+
+func TestUDPTransport(t *testing.T) {
+	options := `{"server_addr": "127.0.0.1:53611"}`
+	testUDPEcho(t, options, options, 200*time.Millisecond)
+}
+
+func TestUDPTransportHopping(t *testing.T) {
+	options := `{"server_addr": "127.0.0.1:0", "port_range": "53620-53639",
+	             "hop_secret": "secret", "hop_interval": 300, "hop_grace": 100}`
+	testUDPEcho(t, options, options, 2*time.Second)
+}
+
+func TestUDPTransportListenAddrs(t *testing.T) {
+	server_options := `{"listen_addrs": ["127.0.0.1:53641-53643", "[::1]:53641"]}`
+	for _, addr := range []string{"127.0.0.1:53641", "127.0.0.1:53643", "[::1]:53641"} {
+		if addr == "[::1]:53641" {
+			if conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback}); err != nil {
+				t.Logf("IPv6 not available, skip %v", addr)
+				continue
+			} else {
+				conn.Close()
+			}
+		}
+		testUDPEcho(t, server_options, `{"server_addr": "`+addr+`"}`, 100*time.Millisecond)
+	}
+}
+
+func TestUDPTransportHoppingPortInUse(t *testing.T) {
+	// ports of the range are in use on one of listen addresses
+	for _, port := range []int{53660, 53661} {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: port})
+		if err != nil {
+			t.Fatalf("Unable to listen: %v", err)
+		}
+		defer conn.Close()
+	}
+	hopping := `"port_range": "53660-53661", "hop_secret": "secret"`
+	testUDPEcho(t, `{"server_addr": "127.0.0.1:0", "listen_addrs": ["127.0.0.2:0"], `+hopping+`}`,
+		`{"server_addr": "127.0.0.1:0", `+hopping+`}`, 200*time.Millisecond)
+
+	if server, err := New("udp", true, []byte(`{"server_addr": "127.0.0.2:0", `+hopping+`}`)); err == nil {
+		server.Close()
+		t.Errorf("Server should fail if no port can be opened")
+	}
+}
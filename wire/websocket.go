@@ -0,0 +1,471 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "io"
+import "net"
+import "fmt"
+import "sync"
+import "time"
+import "bufio"
+import "strings"
+import "net/url"
+import "net/http"
+import "crypto/tls"
+import "crypto/sha1"
+import "crypto/rand"
+import "encoding/base64"
+import "encoding/binary"
+import "encoding/json"
+import log "github.com/Sirupsen/logrus"
+
+// WebSocket (RFC 6455), each packet is a binary message
+
+const WEBSOCKET_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+const WEBSOCKET_MAX_MESSAGE_SIZE = 0xffff
+const WEBSOCKET_PING_INTERVAL = 30 * time.Second
+const WEBSOCKET_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+const (
+	WEBSOCKET_OP_CONTINUATION = 0x0
+	WEBSOCKET_OP_TEXT         = 0x1
+	WEBSOCKET_OP_BINARY       = 0x2
+	WEBSOCKET_OP_CLOSE        = 0x8
+	WEBSOCKET_OP_PING         = 0x9
+	WEBSOCKET_OP_PONG         = 0xa
+)
+
+type WebSocketTransportOptions struct {
+	// server_addr is the address to listen on for server (may be empty if only
+	// used as http.Handler), or the address to connect to for client
+	// (default to host of url). cert and key enables TLS on server,
+	// ca, pin_sha256 and sni are used for "wss" url
+	TLSTransportOptions
+
+	// Client side, e.g. "wss://example.com/ws"
+	URL string `json:"url"`
+	// Client side, Host header, default to host of url
+	Host string `json:"host"`
+	// Client side, extra headers of handshake request
+	Headers map[string]string `json:"headers"`
+	// Server side, path to accept WebSocket on, default to "/"
+	Path string `json:"path"`
+}
+
+type WebSocketTransport struct {
+	TCPTransport
+	path string
+}
+
+func (trans *WebSocketTransport) Open(is_server bool, options json.RawMessage) error {
+	trans.logger = log.WithField("logger", "WebSocketTransport")
+
+	var opt WebSocketTransportOptions
+	if err := json.Unmarshal(options, &opt); err != nil {
+		return err
+	}
+	if is_server {
+		return trans.openServer(opt)
+	}
+
+	u, err := url.Parse(opt.URL)
+	if err != nil {
+		return fmt.Errorf("Bad url: %v", err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return fmt.Errorf("Bad url scheme: %v", u.Scheme)
+	}
+	if len(opt.ServerAddr) == 0 {
+		port := u.Port()
+		if len(port) == 0 {
+			port = "80"
+			if u.Scheme == "wss" {
+				port = "443"
+			}
+		}
+		opt.ServerAddr = net.JoinHostPort(u.Hostname(), port)
+	}
+	server_addr, err := net.ResolveTCPAddr("tcp", opt.ServerAddr)
+	if err != nil {
+		return fmt.Errorf("Error resolving server addr: %v", err)
+	}
+
+	var tls_config *tls.Config
+	if u.Scheme == "wss" {
+		if len(opt.SNI) == 0 {
+			opt.SNI = u.Hostname()
+		}
+		if tls_config, err = opt.clientConfig(); err != nil {
+			return err
+		}
+		tls_config.NextProtos = []string{"http/1.1"}
+	}
+
+	header := make(http.Header)
+	for k, v := range opt.Headers {
+		header.Set(k, v)
+	}
+	host := u.Host
+	if len(opt.Host) > 0 {
+		host = opt.Host
+	}
+
+	dial, err := trans.newDialer(server_addr, opt.TCPTransportOptions)
+	if err != nil {
+		return err
+	}
+	return trans.openFramed("WebSocket", false, server_addr, opt.TCPTransportOptions,
+		func() (FrameConn, error) {
+			var conn net.Conn
+			var err error
+			if tls_config != nil {
+				conn, err = dialTLS(dial, tls_config)
+			} else {
+				conn, err = dial()
+			}
+			if err != nil {
+				return nil, err
+			}
+			ws_conn, err := dialWebSocket(conn, u, host, header)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return ws_conn, nil
+		}, nil)
+}
+
+func (trans *WebSocketTransport) openServer(opt WebSocketTransportOptions) error {
+	trans.path = opt.Path
+	if len(trans.path) == 0 {
+		trans.path = "/"
+	}
+
+	var server_addr *net.TCPAddr
+	if len(opt.ServerAddr) > 0 {
+		var err error
+		if server_addr, err = net.ResolveTCPAddr("tcp", opt.ServerAddr); err != nil {
+			return fmt.Errorf("Error resolving server addr: %v", err)
+		}
+	}
+	if err := trans.openFramed("WebSocket", true, server_addr, opt.TCPTransportOptions, nil, nil); err != nil {
+		return err
+	}
+	if server_addr == nil {
+		return nil
+	}
+
+	listener, err := net.ListenTCP("tcp", server_addr)
+	if err != nil {
+		return fmt.Errorf("Error listening WebSocket: %v", err)
+	}
+	trans.listener = listener
+	if len(opt.Cert) > 0 {
+		cert, err := tls.LoadX509KeyPair(opt.Cert, opt.Key)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("Error loading certificate: %v", err)
+		}
+		trans.listener = tls.NewListener(listener, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"http/1.1"},
+			MinVersion:   tls.VersionTLS12,
+		})
+	}
+	trans.logger.WithFields(log.Fields{
+		"addr": server_addr,
+		"path": trans.path,
+	}).Info("Listening on address")
+
+	mux := http.NewServeMux()
+	mux.Handle(trans.path, trans)
+	go func() {
+		err := http.Serve(trans.listener, mux)
+		select {
+		case <-trans.done:
+		default:
+			trans.logger.WithField("error", err).Error("HTTP server stopped")
+		}
+	}()
+	return nil
+}
+
+// Server side, accept WebSocket connection from request,
+// so that it can be served along with other handlers
+func (trans *WebSocketTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if len(key) == 0 {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Not Supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		trans.logger.WithField("error", err).Warning("Error hijacking connection")
+		return
+	}
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+	trans.serveConn(newWebSocketConn(conn, rw.Reader, false))
+}
+
+// Client side handshake over established connection
+func dialWebSocket(conn net.Conn, u *url.URL, host string, header http.Header) (*WebSocketConn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req_url := *u
+	req_url.Scheme = "http"
+	if u.Scheme == "wss" {
+		req_url.Scheme = "https"
+	}
+	req, err := http.NewRequest(http.MethodGet, req_url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Host = host
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	conn.SetDeadline(time.Now().Add(WEBSOCKET_HANDSHAKE_TIMEOUT))
+	if err = req.Write(conn); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("Bad handshake response status: %v", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		return nil, fmt.Errorf("Bad handshake accept key")
+	}
+	conn.SetDeadline(time.Time{})
+	return newWebSocketConn(conn, reader, true), nil
+}
+
+func webSocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + WEBSOCKET_GUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Whether the comma-separated header contains token, case-insensitively
+func headerContains(header http.Header, name, token string) bool {
+	for _, v := range header[http.CanonicalHeaderKey(name)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Established WebSocket connection, client side masks frames and sends pings
+type WebSocketConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	is_client bool
+
+	write_lock sync.Mutex
+	done       chan struct{}
+	close_once sync.Once
+}
+
+func newWebSocketConn(conn net.Conn, reader *bufio.Reader, is_client bool) *WebSocketConn {
+	ws := &WebSocketConn{
+		Conn:      conn,
+		reader:    reader,
+		is_client: is_client,
+		done:      make(chan struct{}),
+	}
+	// keep idle connection alive through proxies
+	if is_client {
+		go func() {
+			ticker := time.NewTicker(WEBSOCKET_PING_INTERVAL)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ws.done:
+					return
+				case <-ticker.C:
+					if ws.writeMessage(WEBSOCKET_OP_PING, nil) != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+	return ws
+}
+
+func (ws *WebSocketConn) Close() error {
+	ws.close_once.Do(func() {
+		close(ws.done)
+	})
+	return ws.Conn.Close()
+}
+
+func (ws *WebSocketConn) WriteFrame(data []byte) error {
+	return ws.writeMessage(WEBSOCKET_OP_BINARY, data)
+}
+
+// Read next binary message, answering control frames meanwhile
+func (ws *WebSocketConn) ReadFrame() ([]byte, error) {
+	var message []byte
+	var message_op byte
+	for {
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case WEBSOCKET_OP_PING:
+			if err = ws.writeMessage(WEBSOCKET_OP_PONG, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case WEBSOCKET_OP_PONG:
+			continue
+		case WEBSOCKET_OP_CLOSE:
+			ws.writeMessage(WEBSOCKET_OP_CLOSE, nil)
+			return nil, io.EOF
+		case WEBSOCKET_OP_CONTINUATION:
+			if message_op == 0 {
+				return nil, fmt.Errorf("Unexpected continuation frame")
+			}
+		default:
+			message_op = op
+			message = message[:0]
+		}
+		if len(message)+len(payload) > WEBSOCKET_MAX_MESSAGE_SIZE {
+			return nil, fmt.Errorf("WebSocket message too large")
+		}
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+		if message_op == WEBSOCKET_OP_BINARY {
+			return message, nil
+		}
+		// ignore text messages
+		message_op = 0
+	}
+}
+
+func (ws *WebSocketConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	op := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > WEBSOCKET_MAX_MESSAGE_SIZE {
+		return false, 0, nil, fmt.Errorf("WebSocket frame too large: %v", length)
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+func (ws *WebSocketConn) writeMessage(op byte, data []byte) error {
+	if len(data) > WEBSOCKET_MAX_MESSAGE_SIZE {
+		return fmt.Errorf("WebSocket message too large: %v", len(data))
+	}
+	buf := make([]byte, 0, 2+2+4+len(data))
+	buf = append(buf, 0x80|op)
+	var mask_bit byte
+	if ws.is_client {
+		mask_bit = 0x80
+	}
+	if len(data) < 126 {
+		buf = append(buf, mask_bit|byte(len(data)))
+	} else {
+		buf = append(buf, mask_bit|126, byte(len(data)>>8), byte(len(data)))
+	}
+	if ws.is_client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		buf = append(buf, mask[:]...)
+		for i, b := range data {
+			buf = append(buf, b^mask[i%4])
+		}
+	} else {
+		buf = append(buf, data...)
+	}
+
+	ws.write_lock.Lock()
+	defer ws.write_lock.Unlock()
+	ws.SetWriteDeadline(time.Now().Add(TCP_WRITE_TIMEOUT))
+	_, err := ws.Write(buf)
+	return err
+}
@@ -0,0 +1,76 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "time"
+import "bytes"
+import "strings"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+
+func TestWebSocketTransport(t *testing.T) {
+	server, err := New("websocket", true, []byte(`{"server_addr": "127.0.0.1:53581", "path": "/ws"}`))
+	if err != nil {
+		t.Fatalf("Unable to open server: %v", err)
+	}
+	defer server.Close()
+	client, err := New("websocket", false, []byte(`{"url": "ws://127.0.0.1:53581/ws"}`))
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	defer client.Close()
+
+	testTransportEcho(t, server, client,
+		[]byte("hello"), bytes.Repeat([]byte("justvpn"), client.MTU()/7))
+	if nets := client.GetWireNetworks(); len(nets) != 1 || nets[0].String() != "127.0.0.1/32" {
+		t.Errorf("Bad wire networks: %v", nets)
+	}
+}
+
+func TestWebSocketTransportHandler(t *testing.T) {
+	server, err := New("websocket", true, []byte(`{}`))
+	if err != nil {
+		t.Fatalf("Unable to open server: %v", err)
+	}
+	defer server.Close()
+
+	// serve along with other handlers, check headers set by client
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("It works!"))
+	})
+	mux.HandleFunc("/tunnel", func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "cdn.example.com" || r.Header.Get("X-Token") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		server.(*WebSocketTransport).ServeHTTP(w, r)
+	})
+	http_server := httptest.NewServer(mux)
+	defer http_server.Close()
+
+	url := strings.Replace(http_server.URL, "http://", "ws://", 1) + "/tunnel"
+	client, err := New("websocket", false, []byte(`{"url": "`+url+`",
+	                   "host": "cdn.example.com", "headers": {"X-Token": "secret"}}`))
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	defer client.Close()
+	testTransportEcho(t, server, client, []byte("hello"))
+
+	bad_client, err := New("websocket", false, []byte(`{"url": "`+url+`"}`))
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	defer bad_client.Close()
+	// server is already echoing
+	if newTestEcho(nil, bad_client).Echo([]byte("hello"), time.Second) {
+		t.Errorf("Client without token should not be accepted")
+	}
+}
@@ -0,0 +1,261 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "fmt"
+import "sync"
+import "time"
+import "bytes"
+import "strings"
+import "crypto/rand"
+import "encoding/hex"
+import "encoding/xml"
+import "encoding/base64"
+import log "github.com/Sirupsen/logrus"
+
+const XMPP_IBB_NS = "http://jabber.org/protocol/ibb"
+const XMPP_STANZAS_NS = "urn:ietf:params:xml:ns:xmpp-stanzas"
+const XMPP_IBB_DEFAULT_BLOCK_SIZE = 4096
+const XMPP_IBB_WINDOW = 8
+const XMPP_IBB_ACK_TIMEOUT = 10 * time.Second
+
+// Child element of IBB IQ: open, data or close
+type xmppIBBElement struct {
+	XMLName   xml.Name
+	SID       string `xml:"sid,attr"`
+	Seq       uint16 `xml:"seq,attr"`
+	BlockSize int    `xml:"block-size,attr"`
+	Stanza    string `xml:"stanza,attr"`
+	Data      string `xml:",chardata"`
+}
+
+func parseXMPPIBB(query []byte) (*xmppIBBElement, bool) {
+	var elem xmppIBBElement
+	if err := xml.Unmarshal(query, &elem); err != nil || elem.XMLName.Space != XMPP_IBB_NS {
+		return nil, false
+	}
+	return &elem, true
+}
+
+func xmppEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// JID without resource
+func xmppBareJID(jid string) string {
+	if idx := strings.Index(jid, "/"); idx >= 0 {
+		return jid[:idx]
+	}
+	return jid
+}
+
+func xmppIQResult(to, id string) string {
+	return fmt.Sprintf("<iq type='result' to='%s' id='%s'/>", xmppEscape(to), xmppEscape(id))
+}
+
+func xmppIQError(to, id, condition string) string {
+	return fmt.Sprintf("<iq type='error' to='%s' id='%s'><error type='cancel'><%s xmlns='%s'/></error></iq>",
+		xmppEscape(to), xmppEscape(id), condition, XMPP_STANZAS_NS)
+}
+
+// XEP-0047 In-Band Bytestream between the two accounts.
+//
+// Full JID of peer is learned from directed presence, then client side opens
+// the stream (which is bidirectional). Each batch is sent as a data IQ
+// acknowledged by peer, at most XMPP_IBB_WINDOW batches are unacknowledged.
+// The stream is reset if an ack times out or fails, chat is used meanwhile.
+type xmppIBBStream struct {
+	lock       sync.Mutex
+	peer       string // full JID of remote
+	sid        string
+	open_id    string // id of open request waiting for result
+	opened     bool
+	refused    bool // peer does not support it, do not try again until peer changes
+	block_size int  // max bytes of batch sent
+	send_seq   uint16
+	recv_seq   uint16
+	unacked    map[string]time.Time
+	next_id    uint64
+
+	// notified when data is acknowledged
+	acked chan struct{}
+
+	logger *log.Entry
+}
+
+func newXMPPIBBStream() *xmppIBBStream {
+	return &xmppIBBStream{
+		unacked: make(map[string]time.Time),
+		acked:   make(chan struct{}, 1),
+		logger:  log.WithField("logger", "XMPPIBBStream"),
+	}
+}
+
+func (s *xmppIBBStream) reset() {
+	s.sid = ""
+	s.open_id = ""
+	s.opened = false
+	s.send_seq = 0
+	s.recv_seq = 0
+	s.unacked = make(map[string]time.Time)
+}
+
+func (s *xmppIBBStream) newID() string {
+	s.next_id += 1
+	return fmt.Sprintf("ibb%d", s.next_id)
+}
+
+// Update full JID of peer, return whether it's changed
+func (s *xmppIBBStream) SetPeer(jid string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.peer == jid {
+		return false
+	}
+	s.peer = jid
+	s.refused = false
+	s.reset()
+	return true
+}
+
+// Return open request to send if the stream should be opened
+func (s *xmppIBBStream) Open(block_size int) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.peer) == 0 || s.opened || s.refused || len(s.open_id) > 0 {
+		return "", false
+	}
+	var sid [8]byte
+	rand.Read(sid[:])
+	s.reset()
+	s.sid = hex.EncodeToString(sid[:])
+	s.open_id = s.newID()
+	s.block_size = block_size
+	return fmt.Sprintf("<iq type='set' to='%s' id='%s'><open xmlns='%s' block-size='%d' sid='%s' stanza='iq'/></iq>",
+		xmppEscape(s.peer), s.open_id, XMPP_IBB_NS, block_size, s.sid), true
+}
+
+// Open request from peer
+func (s *xmppIBBStream) Accept(from string, elem *xmppIBBElement) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.peer = from
+	s.refused = false
+	s.reset()
+	s.sid = elem.SID
+	s.opened = true
+	s.block_size = elem.BlockSize
+	s.logger.WithField("sid", s.sid).Info("Stream opened by peer")
+}
+
+// Data from peer, return whether it belongs to the stream
+func (s *xmppIBBStream) Receive(elem *xmppIBBElement) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.opened || elem.SID != s.sid {
+		return false
+	}
+	if elem.Seq != s.recv_seq {
+		// packets may be lost anyway, just follow
+		s.logger.WithFields(log.Fields{
+			"expected": s.recv_seq,
+			"seq":      elem.Seq,
+		}).Debug("Unexpected sequence")
+	}
+	s.recv_seq = elem.Seq + 1
+	return true
+}
+
+// Close request from peer
+func (s *xmppIBBStream) Close(sid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sid == s.sid {
+		s.logger.WithField("sid", sid).Info("Stream closed by peer")
+		s.reset()
+	}
+}
+
+func (s *xmppIBBStream) HandleResult(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.open_id) > 0 && id == s.open_id {
+		s.open_id = ""
+		s.opened = true
+		s.logger.WithField("sid", s.sid).Info("Stream opened")
+		return
+	}
+	if _, ok := s.unacked[id]; ok {
+		delete(s.unacked, id)
+		select {
+		case s.acked <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Return whether the stream is reset by the error
+func (s *xmppIBBStream) HandleError(id string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.open_id) > 0 && id == s.open_id {
+		s.logger.Info("Stream refused by peer, use chat")
+		s.reset()
+		s.refused = true
+		return true
+	}
+	if _, ok := s.unacked[id]; ok {
+		s.logger.Warning("Data refused by peer, reset stream")
+		s.reset()
+		return true
+	}
+	return false
+}
+
+// Max bytes of batch if the stream can be used now, zero otherwise.
+// The stream is reset if any ack times out
+func (s *xmppIBBStream) Ready(now time.Time) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.opened {
+		return 0
+	}
+	for _, sent := range s.unacked {
+		if now.Sub(sent) > XMPP_IBB_ACK_TIMEOUT {
+			s.logger.Warning("Timeout waiting for ack, reset stream")
+			s.reset()
+			return 0
+		}
+	}
+	return s.block_size
+}
+
+// Whether too many batches are unacknowledged
+func (s *xmppIBBStream) Full() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.unacked) >= XMPP_IBB_WINDOW
+}
+
+// Return data IQ carrying body, false if the stream is not opened
+func (s *xmppIBBStream) Data(body []byte, now time.Time) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.opened {
+		return "", false
+	}
+	id := s.newID()
+	s.unacked[id] = now
+	seq := s.send_seq
+	s.send_seq += 1
+	return fmt.Sprintf("<iq type='set' to='%s' id='%s'><data xmlns='%s' seq='%d' sid='%s'>%s</data></iq>",
+		xmppEscape(s.peer), id, XMPP_IBB_NS, seq, xmppEscape(s.sid),
+		base64.StdEncoding.EncodeToString(body)), true
+}
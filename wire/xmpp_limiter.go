@@ -0,0 +1,160 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "sync"
+import "time"
+import "strings"
+
+const XMPP_DEFAULT_RATE = 10.0
+const XMPP_DEFAULT_BURST = 20
+const XMPP_MIN_RATE = 0.2
+const XMPP_MIN_THROTTLE_PAUSE = 5 * time.Second
+const XMPP_MAX_THROTTLE_PAUSE = time.Minute
+const XMPP_RATE_RECOVER_INTERVAL = 10 * time.Second
+
+type xmppRate struct {
+	rate  float64
+	burst int
+}
+
+// Messages per second and burst accepted by known servers, by domain suffix
+var XMPP_SERVER_RATES = map[string]xmppRate{
+	"renren.com": {1, 5},
+	"gmail.com":  {5, 10},
+	"google.com": {5, 10},
+	"jabber.org": {2, 5},
+}
+
+// Default rate for server of host or JID domain
+func xmppServerRate(host, domain string) (float64, int) {
+	host = strings.Split(host, ":")[0]
+	for suffix, rate := range XMPP_SERVER_RATES {
+		for _, name := range []string{host, domain} {
+			if name == suffix || strings.HasSuffix(name, "."+suffix) {
+				return rate.rate, rate.burst
+			}
+		}
+	}
+	return XMPP_DEFAULT_RATE, XMPP_DEFAULT_BURST
+}
+
+// Token bucket limiting messages sent to XMPP server.
+//
+// When server complains, the rate halves and sending pauses for a while
+// (doubling if it keeps complaining). The rate recovers by a tenth of
+// max rate for every quiet interval.
+type XMPPRateLimiter struct {
+	max_rate float64
+	burst    float64
+
+	lock         sync.Mutex
+	rate         float64
+	tokens       float64
+	refilled     time.Time
+	pause        time.Duration
+	paused_until time.Time
+	throttled    time.Time // last time complained or recovered
+	throttles    int       // complaints since fully recovered
+}
+
+func NewXMPPRateLimiter(rate float64, burst int) *XMPPRateLimiter {
+	if rate < XMPP_MIN_RATE {
+		rate = XMPP_MIN_RATE
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	now := time.Now()
+	return &XMPPRateLimiter{
+		max_rate:  rate,
+		burst:     float64(burst),
+		rate:      rate,
+		tokens:    float64(burst),
+		refilled:  now,
+		throttled: now,
+	}
+}
+
+func (l *XMPPRateLimiter) Rate() float64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.rate
+}
+
+// Time to wait before sending next message, the token is taken if it's zero
+func (l *XMPPRateLimiter) Reserve(now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	wait := l.delay(now)
+	if wait == 0 {
+		l.tokens -= 1
+	}
+	return wait
+}
+
+// Time to wait before next message can be sent, without taking the token
+func (l *XMPPRateLimiter) Delay(now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.delay(now)
+}
+
+func (l *XMPPRateLimiter) delay(now time.Time) time.Duration {
+	if now.Before(l.paused_until) {
+		return l.paused_until.Sub(now)
+	}
+	if l.rate < l.max_rate && now.Sub(l.throttled) >= XMPP_RATE_RECOVER_INTERVAL {
+		l.rate += l.max_rate / 10
+		if l.rate >= l.max_rate {
+			l.rate = l.max_rate
+			l.pause = 0
+			l.throttles = 0
+		}
+		l.throttled = now
+	}
+
+	if elapsed := now.Sub(l.refilled); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.refilled = now
+
+	if l.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Server complains about too many messages,
+// return number of complaints since the rate was fully recovered
+func (l *XMPPRateLimiter) Throttle(now time.Time) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now.Before(l.paused_until) {
+		// complaints about messages sent before pausing
+		return l.throttles
+	}
+	l.throttles += 1
+	if l.rate /= 2; l.rate < XMPP_MIN_RATE {
+		l.rate = XMPP_MIN_RATE
+	}
+	if l.pause *= 2; l.pause < XMPP_MIN_THROTTLE_PAUSE {
+		l.pause = XMPP_MIN_THROTTLE_PAUSE
+	} else if l.pause > XMPP_MAX_THROTTLE_PAUSE {
+		l.pause = XMPP_MAX_THROTTLE_PAUSE
+	}
+	l.tokens = 0
+	l.refilled = now.Add(l.pause)
+	l.paused_until = now.Add(l.pause)
+	l.throttled = now
+	return l.throttles
+}
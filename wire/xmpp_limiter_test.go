@@ -0,0 +1,66 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "time"
+import "testing"
+
+func TestXMPPRateLimiter(t *testing.T) {
+	limiter := NewXMPPRateLimiter(10, 5)
+	now := time.Now()
+	for i := 0; i < 5; i += 1 {
+		if wait := limiter.Reserve(now); wait != 0 {
+			t.Fatalf("Burst should not wait: %v", wait)
+		}
+	}
+	if wait := limiter.Reserve(now); wait <= 0 || wait > 100*time.Millisecond {
+		t.Errorf("Bad wait after burst: %v", wait)
+	}
+	now = now.Add(100 * time.Millisecond)
+	if wait := limiter.Reserve(now); wait != 0 {
+		t.Errorf("Token should be refilled: %v", wait)
+	}
+
+	now = now.Add(100 * time.Millisecond)
+	if limiter.Delay(now) != 0 {
+		t.Errorf("Token should be available")
+	}
+	if n := limiter.Throttle(now); n != 1 {
+		t.Errorf("Bad number of throttles: %v", n)
+	}
+	if limiter.Rate() != 5 {
+		t.Errorf("Rate should halve: %v", limiter.Rate())
+	}
+	// complaints during pause are ignored
+	if n := limiter.Throttle(now.Add(time.Second)); n != 1 {
+		t.Errorf("Bad number of throttles: %v", n)
+	}
+	if limiter.Rate() != 5 {
+		t.Errorf("Rate should not halve again during pause: %v", limiter.Rate())
+	}
+	if wait := limiter.Reserve(now.Add(time.Second)); wait != XMPP_MIN_THROTTLE_PAUSE-time.Second {
+		t.Errorf("Should pause after throttled: %v", wait)
+	}
+
+	// recovers after quiet intervals
+	for i := 1; i <= 5; i += 1 {
+		limiter.Reserve(now.Add(time.Duration(i) * XMPP_RATE_RECOVER_INTERVAL))
+	}
+	if limiter.Rate() != 10 {
+		t.Errorf("Rate should recover: %v", limiter.Rate())
+	}
+}
+
+func TestXMPPServerRate(t *testing.T) {
+	if rate, _ := xmppServerRate("talk.renren.com:5222", "renren.com"); rate != 1 {
+		t.Errorf("Bad rate for renren: %v", rate)
+	}
+	if rate, _ := xmppServerRate("xmpp.example.com:5222", "example.com"); rate != XMPP_DEFAULT_RATE {
+		t.Errorf("Bad default rate: %v", rate)
+	}
+}
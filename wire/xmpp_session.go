@@ -0,0 +1,534 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "sync"
+import "time"
+import "strings"
+import "crypto/tls"
+import "crypto/x509"
+import "github.com/mattn/go-xmpp"
+import log "github.com/Sirupsen/logrus"
+
+// Connection of one account pair of XMPPTransport.
+// Reconnects and logins again whenever connection is lost.
+// Batches are sent as chats limited by rate limiter, or via In-Band Bytestream
+// if it's opened, which is not limited but flow controlled by acks.
+// The account is banned for a while if the server says so, or keeps complaining.
+type xmppSession struct {
+	trans     *XMPPTransport
+	remote_id string
+	xmpp_opts xmpp.Options
+	limiter   *XMPPRateLimiter
+	ibb       *xmppIBBStream
+
+	lock         sync.Mutex
+	client       xmppClient
+	last_recv    time.Time // last time received data from peer
+	banned_until time.Time
+	// notified when data is received, or session is unbanned
+	wake chan struct{}
+
+	logger *log.Entry
+}
+
+func newXMPPSession(trans *XMPPTransport, pair XMPPAccountPair, host string, root_cas *x509.CertPool) *xmppSession {
+	username, passwd := pair.ClientUsername, pair.ClientPassword
+	remote_id := pair.ServerUsername
+	if trans.is_server {
+		username, passwd = pair.ServerUsername, pair.ServerPassword
+		remote_id = pair.ClientUsername
+	}
+	s := &xmppSession{
+		trans:     trans,
+		remote_id: xmppBareJID(remote_id),
+		ibb:       newXMPPIBBStream(),
+		wake:      make(chan struct{}, 1),
+		logger: log.WithFields(log.Fields{
+			"logger":  "XMPPTransport",
+			"account": username,
+		}),
+	}
+
+	domain := xmppDomain(username)
+	rate, burst := xmppServerRate(trans.opt.Host, domain)
+	if trans.opt.Rate > 0 {
+		rate = trans.opt.Rate
+	}
+	if trans.opt.Burst > 0 {
+		burst = trans.opt.Burst
+	}
+	s.limiter = NewXMPPRateLimiter(rate, burst)
+
+	tls_config := &tls.Config{
+		ServerName: trans.opt.SNI,
+		RootCAs:    root_cas,
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(tls_config.ServerName) == 0 {
+		tls_config.ServerName = domain
+	}
+	s.xmpp_opts = xmpp.Options{
+		Host:     host,
+		User:     username,
+		Password: passwd,
+		// no direct TLS, upgrade by StartTLS
+		NoTLS:                        true,
+		StartTLS:                     !trans.opt.NoTLS,
+		TLSConfig:                    tls_config,
+		InsecureAllowUnencryptedAuth: trans.opt.NoTLS,
+		Debug:                        false,
+	}
+	s.logger.WithFields(log.Fields{
+		"server":    trans.opt.Host,
+		"remote_id": s.remote_id,
+		"tls":       !trans.opt.NoTLS,
+		"rate":      rate,
+	}).Info("Connecting to remote")
+	return s
+}
+
+// Connect and login, the client is used for writing since then
+func (s *xmppSession) connect() (xmppClient, error) {
+	client, err := xmppDial(s.xmpp_opts)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	s.client = client
+	s.lock.Unlock()
+
+	// peer may be reconnected too, learn its full JID again.
+	// directed presence also makes server tell peer when we are gone
+	s.ibb.SetPeer("")
+	if _, err := client.SendPresence(xmpp.Presence{To: s.remote_id}); err != nil {
+		s.logger.WithField("error", err).Warning("Error sending presence")
+	}
+	return client, nil
+}
+
+func (s *xmppSession) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.client != nil {
+		s.client.Close()
+	}
+}
+
+// Stop using the account for a while
+func (s *xmppSession) ban(reason string) {
+	s.lock.Lock()
+	s.banned_until = time.Now().Add(XMPP_BAN_TIME)
+	client := s.client
+	s.lock.Unlock()
+	s.logger.WithField("reason", reason).Warning("Account banned, rotate to others")
+	if client != nil {
+		client.Close()
+	}
+}
+
+// Time left before the account is unbanned
+func (s *xmppSession) bannedFor(now time.Time) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if now.Before(s.banned_until) {
+		return s.banned_until.Sub(now)
+	}
+	return 0
+}
+
+func (s *xmppSession) peerActive(now time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return now.Sub(s.last_recv) < XMPP_PEER_TIMEOUT
+}
+
+// Whether the session should take packets to send now.
+// Server side only uses sessions which client is using, if any
+func (s *xmppSession) usable(now time.Time) bool {
+	s.lock.Lock()
+	usable := s.client != nil && !now.Before(s.banned_until)
+	s.lock.Unlock()
+	if !usable || !s.trans.is_server {
+		return usable
+	}
+	return s.peerActive(now) || !s.trans.anyPeerActive(now)
+}
+
+func (s *xmppSession) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Read from client until it's broken, then connect again (after ban if banned)
+func (s *xmppSession) keepConnected(client xmppClient) {
+	interval := XMPP_MIN_RECONNECT_INTERVAL
+	for {
+		if client != nil {
+			select {
+			case <-s.trans.done:
+				// closed while connecting
+				client.Close()
+				return
+			default:
+			}
+			s.receive(client)
+			client.Close()
+			s.lock.Lock()
+			if s.client == client {
+				s.client = nil
+			}
+			s.lock.Unlock()
+		}
+
+		for {
+			wait := interval
+			if banned := s.bannedFor(time.Now()); banned > wait {
+				wait = banned
+			}
+			select {
+			case <-s.trans.done:
+				return
+			case <-time.After(wait):
+			}
+			var err error
+			if client, err = s.connect(); err == nil {
+				s.logger.Info("Reconnected")
+				interval = XMPP_MIN_RECONNECT_INTERVAL
+				s.notify()
+				break
+			}
+			s.logger.WithField("error", err).Warning("Error connecting")
+			// only SASL failure of credentials, not e.g. network errors during auth
+			if strings.Contains(err.Error(), "not-authorized") {
+				s.ban(err.Error())
+			}
+			if interval *= 2; interval > XMPP_MAX_RECONNECT_INTERVAL {
+				interval = XMPP_MAX_RECONNECT_INTERVAL
+			}
+		}
+	}
+}
+
+// Send presence periodically, so that server does not consider us idle
+func (s *xmppSession) keepPresence() {
+	ticker := time.NewTicker(time.Duration(s.trans.opt.PresenceInterval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.trans.done:
+			return
+		case <-ticker.C:
+		}
+		s.lock.Lock()
+		client := s.client
+		s.lock.Unlock()
+		if client == nil {
+			continue
+		}
+		if _, err := client.SendPresence(xmpp.Presence{}); err != nil {
+			s.logger.WithField("error", err).Warning("Error sending presence, close connection")
+			client.Close()
+		}
+	}
+}
+
+// Receive stanzas from remote until connection is broken
+func (s *xmppSession) receive(client xmppClient) {
+	for {
+		msg, err := client.Recv()
+		if err != nil {
+			select {
+			case <-s.trans.done:
+			default:
+				s.logger.WithField("error", err).Warning("Connection lost")
+			}
+			return
+		}
+		switch msg := msg.(type) {
+		case xmpp.Chat:
+			s.handleChat(msg)
+		case xmpp.Presence:
+			s.handlePresence(client, msg)
+		case xmpp.IQ:
+			s.handleIQ(client, msg)
+		}
+	}
+}
+
+func (s *xmppSession) handleChat(chat xmpp.Chat) {
+	// "chat_retry" is redelivery of message which was not delivered in time,
+	// the content is the same as normal chat. "error" bounces our own message
+	if chat.Type == "error" {
+		s.logger.Debug("Message bounced by server")
+		return
+	}
+	if len(chat.Text) == 0 {
+		s.logger.Warning("Empty text")
+		return
+	}
+	from_peer := xmppBareJID(chat.Remote) == s.remote_id
+	if from_peer {
+		if dec_buf, err := s.trans.encoder.DecodeString(chat.Text); err == nil {
+			s.deliver(dec_buf)
+			return
+		}
+	}
+
+	// not packets, server may be complaining, but never trust signs from users
+	from_server := xmppIsServerJID(chat.Remote)
+	switch {
+	case from_server && isXMPPSign(chat.Text, XMPP_BAN_SIGNS):
+		s.ban(chat.Text)
+	case from_server && isXMPPSign(chat.Text, XMPP_THROTTLE_SIGNS):
+		throttles := s.limiter.Throttle(time.Now())
+		s.logger.WithField("rate", s.limiter.Rate()).
+			Warning("Server complains about too much messages, slow down")
+		if throttles >= XMPP_BAN_THROTTLES {
+			s.ban("Throttled too many times")
+		}
+	case from_peer:
+		s.logger.WithField("text", chat.Text).Warning("Unable to decode")
+	default:
+		s.logger.WithField("remote_id", chat.Remote).
+			Warning("Remote ID does not match")
+	}
+}
+
+// Learn full JID of peer, and open IBB to it
+func (s *xmppSession) handlePresence(client xmppClient, presence xmpp.Presence) {
+	if xmppBareJID(presence.From) != s.remote_id || presence.From == s.remote_id {
+		return
+	}
+	if presence.Type == "unavailable" {
+		// peer is gone (e.g. banned), do not reply via this session
+		s.lock.Lock()
+		s.last_recv = time.Time{}
+		s.lock.Unlock()
+		s.ibb.SetPeer("")
+		return
+	}
+	if s.trans.opt.NoIBB {
+		return
+	}
+	if !s.ibb.SetPeer(presence.From) {
+		return
+	}
+	s.logger.WithField("peer", presence.From).Debug("Peer is available")
+	// let peer know our full JID too
+	client.SendPresence(xmpp.Presence{To: presence.From})
+	s.openIBB(client)
+}
+
+func (s *xmppSession) openIBB(client xmppClient) {
+	if s.trans.is_server {
+		// opened by client
+		return
+	}
+	if stanza, ok := s.ibb.Open(s.trans.opt.IBBBlockSize); ok {
+		client.SendOrg(stanza)
+	}
+}
+
+func (s *xmppSession) handleIQ(client xmppClient, iq xmpp.IQ) {
+	if xmppBareJID(iq.From) != s.remote_id {
+		return
+	}
+	switch iq.Type {
+	case "result":
+		s.ibb.HandleResult(iq.ID)
+		return
+	case "error":
+		if s.ibb.HandleError(iq.ID) {
+			s.openIBB(client)
+		}
+		return
+	case "set":
+	default:
+		return
+	}
+
+	elem, ok := parseXMPPIBB(iq.Query)
+	if !ok || s.trans.opt.NoIBB {
+		client.SendOrg(xmppIQError(iq.From, iq.ID, "feature-not-implemented"))
+		return
+	}
+	switch elem.XMLName.Local {
+	case "open":
+		if elem.Stanza == "message" || elem.BlockSize < s.trans.mtu+2 {
+			client.SendOrg(xmppIQError(iq.From, iq.ID, "not-acceptable"))
+			return
+		}
+		s.ibb.Accept(iq.From, elem)
+	case "close":
+		s.ibb.Close(elem.SID)
+	case "data":
+		if !s.ibb.Receive(elem) {
+			client.SendOrg(xmppIQError(iq.From, iq.ID, "item-not-found"))
+			return
+		}
+		client.SendOrg(xmppIQResult(iq.From, iq.ID))
+		dec_buf, err := s.trans.encoder.DecodeString(strings.Join(strings.Fields(elem.Data), ""))
+		if err != nil {
+			s.logger.WithField("error", err).Warning("Unable to decode IBB data")
+			return
+		}
+		s.deliver(dec_buf)
+		return
+	default:
+		client.SendOrg(xmppIQError(iq.From, iq.ID, "bad-request"))
+		return
+	}
+	client.SendOrg(xmppIQResult(iq.From, iq.ID))
+}
+
+func (s *xmppSession) deliver(batch []byte) {
+	s.lock.Lock()
+	s.last_recv = time.Now()
+	s.lock.Unlock()
+	s.notify()
+	s.trans.deliver(batch)
+}
+
+// Return max bytes of batch and whether to use IBB if able to send now,
+// otherwise time to wait before checking again
+func (s *xmppSession) ready(now time.Time) (int, bool, time.Duration) {
+	if !s.usable(now) {
+		return 0, false, XMPP_MIN_RECONNECT_INTERVAL
+	}
+	if block_size := s.ibb.Ready(now); block_size > 0 {
+		if !s.ibb.Full() {
+			return block_size, true, 0
+		}
+		return 0, false, XMPP_IBB_ACK_TIMEOUT
+	}
+	if wait := s.limiter.Delay(now); wait > 0 {
+		return 0, false, wait
+	}
+	return s.trans.opt.BatchSize, false, 0
+}
+
+// Wait until able to send, return max bytes of batch and whether to use IBB,
+// zero if transport is closed
+func (s *xmppSession) waitReady() (int, bool) {
+	for {
+		batch_size, via_ibb, wait := s.ready(time.Now())
+		if wait == 0 {
+			return batch_size, via_ibb
+		}
+		select {
+		case <-s.trans.done:
+			return 0, false
+		case <-s.wake:
+		case <-s.ibb.acked:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Next queued packet without waiting, those handed back first, nil if none
+func (s *xmppSession) next() []byte {
+	select {
+	case packet := <-s.trans.retry_buf:
+		return packet
+	default:
+	}
+	select {
+	case packet := <-s.trans.send_buf:
+		return packet
+	default:
+		return nil
+	}
+}
+
+// Let any session (including this one) send packet taken but not sent,
+// before newer ones
+func (s *xmppSession) handBack(packet []byte) {
+	select {
+	case s.trans.retry_buf <- packet:
+	case <-s.trans.done:
+	}
+}
+
+// Take queued packets and send them in batches, only when able to send,
+// so that packets are taken by other sessions meanwhile
+func (s *xmppSession) sendLoop() {
+	for {
+		batch_size, via_ibb := s.waitReady()
+		if batch_size == 0 {
+			return
+		}
+		pending := s.next()
+		if pending == nil {
+			select {
+			case <-s.trans.done:
+				return
+			case pending = <-s.trans.retry_buf:
+			case pending = <-s.trans.send_buf:
+			case <-time.After(XMPP_MIN_RECONNECT_INTERVAL):
+				// check whether still usable
+				continue
+			}
+		}
+		// IBB may be opened, or session banned while waiting for packets
+		var wait time.Duration
+		if batch_size, via_ibb, wait = s.ready(time.Now()); wait > 0 {
+			s.handBack(pending)
+			continue
+		}
+		if !via_ibb && s.limiter.Reserve(time.Now()) > 0 {
+			// throttled meanwhile, let others send it
+			s.handBack(pending)
+			continue
+		}
+
+		body := make([]byte, 0, batch_size)
+		for pending != nil && (len(body) == 0 || len(body)+2+len(pending) <= batch_size) {
+			body = append(body, byte(len(pending)>>8), byte(len(pending)))
+			body = append(body, pending...)
+			pending = s.next()
+		}
+		s.send(body, via_ibb)
+		if pending != nil {
+			// does not fit, send it in next batch
+			s.handBack(pending)
+		}
+	}
+}
+
+// Send batch via IBB or chat, fallback to chat if IBB is reset meanwhile
+func (s *xmppSession) send(body []byte, via_ibb bool) {
+	s.lock.Lock()
+	client := s.client
+	s.lock.Unlock()
+	if client == nil {
+		// not connected, drop it
+		return
+	}
+
+	if via_ibb {
+		if stanza, ok := s.ibb.Data(body, time.Now()); ok {
+			if _, err := client.SendOrg(stanza); err != nil {
+				s.logger.WithField("error", err).Warning("Error sending, close connection")
+				client.Close()
+			}
+			return
+		}
+	}
+	msg := xmpp.Chat{
+		Remote: s.remote_id,
+		Type:   "chat",
+		Text:   s.trans.encoder.EncodeToString(body),
+	}
+	if _, err := client.Send(msg); err != nil {
+		s.logger.WithField("error", err).Warning("Error sending, close connection")
+		client.Close()
+	}
+}
@@ -0,0 +1,328 @@
+/*
+* @Author: BlahGeek
+* @Date:   2026-10-19
+* @Last Modified by:   BlahGeek
+* @Last Modified time: 2026-10-19
+ */
+
+package wire
+
+import "io"
+import "sync"
+import "time"
+import "bytes"
+import "testing"
+import "encoding/xml"
+import "github.com/mattn/go-xmpp"
+
+func TestXMPPDomain(t *testing.T) {
+	for jid, domain := range map[string]string{
+		"vpn@example.com":          "example.com",
+		"vpn@example.com/resource": "example.com",
+		"example.com":              "example.com",
+	} {
+		if d := xmppDomain(jid); d != domain {
+			t.Errorf("Domain of %v: %v, expected %v", jid, d, domain)
+		}
+	}
+	if !xmppIsServerJID("example.com/resource") || xmppIsServerJID("vpn@example.com") {
+		t.Errorf("Bad server JID check")
+	}
+}
+
+func TestXMPPTransportUsernames(t *testing.T) {
+	trans := &XMPPTransport{}
+	if err := trans.Open(false, []byte(`{"client_username": "a@example.com"}`)); err == nil {
+		t.Errorf("Open without server username should fail")
+	}
+}
+
+func TestSplitXMPPBatch(t *testing.T) {
+	packets, err := splitXMPPBatch([]byte{0, 3, 'a', 'b', 'c', 0, 0, 0, 1, 'd'})
+	if err != nil || len(packets) != 3 || !bytes.Equal(packets[0], []byte("abc")) ||
+		len(packets[1]) != 0 || !bytes.Equal(packets[2], []byte("d")) {
+		t.Errorf("Bad packets: %v, %v", packets, err)
+	}
+	packets, err = splitXMPPBatch([]byte{0, 1, 'a', 0, 5, 'b'})
+	if err == nil || len(packets) != 1 {
+		t.Errorf("Truncated batch should fail after first packet: %v, %v", packets, err)
+	}
+}
+
+// In-memory XMPP server routing stanzas between logged in accounts
+type testXMPPServer struct {
+	lock    sync.Mutex
+	clients map[string]*testXMPPClient // by bare JID
+	chats   int
+	iqs     int
+	sent    map[string]int // chats by bare JID of sender
+}
+
+type testXMPPClient struct {
+	server   *testXMPPServer
+	jid      string
+	directed []string // sent directed presence to, told when we are gone
+	recv     chan interface{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newTestXMPPServer() *testXMPPServer {
+	return &testXMPPServer{
+		clients: make(map[string]*testXMPPClient),
+		sent:    make(map[string]int),
+	}
+}
+
+func (s *testXMPPServer) dial(opts xmpp.Options) (xmppClient, error) {
+	c := &testXMPPClient{
+		server: s,
+		jid:    opts.User + "/test",
+		recv:   make(chan interface{}, 256),
+		done:   make(chan struct{}),
+	}
+	s.lock.Lock()
+	s.clients[opts.User] = c
+	s.lock.Unlock()
+	return c, nil
+}
+
+func (s *testXMPPServer) route(to string, msg interface{}) {
+	s.lock.Lock()
+	c := s.clients[xmppBareJID(to)]
+	switch msg.(type) {
+	case xmpp.Chat:
+		s.chats += 1
+	case xmpp.IQ:
+		s.iqs += 1
+	}
+	s.lock.Unlock()
+	if c == nil || (to != xmppBareJID(to) && to != c.jid) {
+		return
+	}
+	select {
+	case c.recv <- msg:
+	case <-c.done:
+	}
+}
+
+func (s *testXMPPServer) counts() (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.chats, s.iqs
+}
+
+func (s *testXMPPServer) sentBy(jid string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.sent[jid]
+}
+
+func (c *testXMPPClient) Send(chat xmpp.Chat) (int, error) {
+	c.server.lock.Lock()
+	c.server.sent[xmppBareJID(c.jid)] += 1
+	c.server.lock.Unlock()
+	c.server.route(chat.Remote, xmpp.Chat{Remote: c.jid, Type: chat.Type, Text: chat.Text})
+	return len(chat.Text), nil
+}
+
+func (c *testXMPPClient) SendOrg(org string) (int, error) {
+	var iq struct {
+		Type  string `xml:"type,attr"`
+		To    string `xml:"to,attr"`
+		ID    string `xml:"id,attr"`
+		Inner []byte `xml:",innerxml"`
+	}
+	if err := xml.Unmarshal([]byte(org), &iq); err != nil {
+		return 0, err
+	}
+	c.server.route(iq.To, xmpp.IQ{ID: iq.ID, From: c.jid, To: iq.To, Type: iq.Type, Query: iq.Inner})
+	return len(org), nil
+}
+
+func (c *testXMPPClient) SendPresence(presence xmpp.Presence) (int, error) {
+	if len(presence.To) > 0 {
+		c.directed = append(c.directed, presence.To)
+		c.server.route(presence.To, xmpp.Presence{From: c.jid, To: presence.To, Type: presence.Type})
+	}
+	return 0, nil
+}
+
+func (c *testXMPPClient) Recv() (interface{}, error) {
+	select {
+	case msg := <-c.recv:
+		return msg, nil
+	case <-c.done:
+		return nil, io.EOF
+	}
+}
+
+func (c *testXMPPClient) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		for _, to := range c.directed {
+			c.server.route(to, xmpp.Presence{From: c.jid, To: to, Type: "unavailable"})
+		}
+	})
+	return nil
+}
+
+// Open transports connected via in-memory server
+func testXMPPPair(t *testing.T, server_options, client_options string) (*testXMPPServer, Transport, Transport) {
+	server := newTestXMPPServer()
+	xmppDial = server.dial
+	server_trans, err := New("xmpp", true, []byte(server_options))
+	if err != nil {
+		t.Fatalf("Unable to open server: %v", err)
+	}
+	client_trans, err := New("xmpp", false, []byte(client_options))
+	if err != nil {
+		t.Fatalf("Unable to open client: %v", err)
+	}
+	return server, server_trans, client_trans
+}
+
+const testXMPPOptions = `"host": "127.0.0.1:5222",
+	"server_username": "server@example.com", "client_username": "client@example.com"`
+
+func TestXMPPTransportIBB(t *testing.T) {
+	dial := xmppDial
+	defer func() { xmppDial = dial }()
+	options := "{" + testXMPPOptions + "}"
+	server, server_trans, client_trans := testXMPPPair(t, options, options)
+	defer server_trans.Close()
+	defer client_trans.Close()
+
+	for start := time.Now(); client_trans.(*XMPPTransport).sessions[0].ibb.Ready(time.Now()) == 0; {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("IBB not opened")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	chats, iqs := server.counts()
+	testTransportEcho(t, server_trans, client_trans,
+		[]byte("hello"), bytes.Repeat([]byte("justvpn"), client_trans.MTU()/7))
+	if new_chats, new_iqs := server.counts(); new_chats != chats || new_iqs == iqs {
+		t.Errorf("Data should be sent via IBB, chats: %v, iqs: %v", new_chats-chats, new_iqs-iqs)
+	}
+}
+
+func TestXMPPTransportIBBFallback(t *testing.T) {
+	dial := xmppDial
+	defer func() { xmppDial = dial }()
+	server, server_trans, client_trans := testXMPPPair(t,
+		`{"no_ibb": true, `+testXMPPOptions+"}", "{"+testXMPPOptions+"}")
+	defer server_trans.Close()
+	defer client_trans.Close()
+
+	testTransportEcho(t, server_trans, client_trans,
+		[]byte("hello"), bytes.Repeat([]byte("justvpn"), client_trans.MTU()/7))
+	if chats, _ := server.counts(); chats == 0 {
+		t.Errorf("Data should be sent via chat")
+	}
+	if client_trans.(*XMPPTransport).sessions[0].ibb.Ready(time.Now()) != 0 {
+		t.Errorf("IBB should not be opened")
+	}
+}
+
+// Send packets from client and wait for all echoed
+func testXMPPEchoMany(t *testing.T, client Transport, received chan []byte, count int) {
+	for i := 0; i < count; i += 1 {
+		client.Write([]byte{byte(i)})
+		time.Sleep(5 * time.Millisecond)
+	}
+	timeout := time.After(3 * time.Second)
+	for i := 0; i < count; i += 1 {
+		select {
+		case <-received:
+		case <-timeout:
+			t.Fatalf("Only %v of %v packets echoed", i, count)
+		}
+	}
+}
+
+func TestXMPPTransportPool(t *testing.T) {
+	dial := xmppDial
+	defer func() { xmppDial = dial }()
+	options := `{"host": "127.0.0.1:5222", "no_ibb": true, "accounts": [
+		{"server_username": "server-a@example.com", "client_username": "client-a@example.com"},
+		{"server_username": "server-b@example.com", "client_username": "client-b@example.com"},
+		{"server_username": "server-c@example.com", "client_username": "client-c@example.com"}]}`
+	server, server_trans, client_trans := testXMPPPair(t, options, options)
+	defer server_trans.Close()
+	defer client_trans.Close()
+
+	echo := newTestEcho(server_trans, client_trans)
+	testXMPPEchoMany(t, client_trans, echo.received, 30)
+	used := 0
+	for _, name := range []string{"client-a", "client-b", "client-c"} {
+		if server.sentBy(name+"@example.com") > 0 {
+			used += 1
+		}
+	}
+	if used < 2 {
+		t.Errorf("Traffic should be spread across accounts, %v used", used)
+	}
+
+	// users (including peer) can not ban us
+	for _, remote := range []string{"someone@example.com", "server-a@example.com/vpn"} {
+		server.route("client-a@example.com", xmpp.Chat{
+			Remote: remote,
+			Type:   "chat",
+			Text:   "Your account is banned",
+		})
+	}
+	time.Sleep(100 * time.Millisecond)
+	if client_trans.(*XMPPTransport).sessions[0].bannedFor(time.Now()) > 0 {
+		t.Fatalf("Account should not be banned by users")
+	}
+
+	server.route("client-a@example.com", xmpp.Chat{
+		Remote: "example.com",
+		Type:   "chat",
+		Text:   "Your account is banned",
+	})
+	for start := time.Now(); client_trans.(*XMPPTransport).sessions[0].bannedFor(time.Now()) == 0; {
+		if time.Since(start) > time.Second {
+			t.Fatalf("Account should be banned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	before := server.sentBy("client-a@example.com")
+	testXMPPEchoMany(t, client_trans, echo.received, 30)
+	if sent := server.sentBy("client-a@example.com"); sent != before {
+		t.Errorf("Banned account should not be used, sent %v", sent-before)
+	}
+}
+
+func TestXMPPTransportOrder(t *testing.T) {
+	dial := xmppDial
+	defer func() { xmppDial = dial }()
+	options := `{"no_ibb": true, "rate": 100, "burst": 100, ` + testXMPPOptions + "}"
+	_, server_trans, client_trans := testXMPPPair(t, options, options)
+	defer server_trans.Close()
+	defer client_trans.Close()
+	echo := testTransportEcho(t, server_trans, client_trans, []byte("hello"))
+
+	// only one packet fits in a batch, the next one taken is handed back
+	const count = 10
+	for i := 0; i < count; i += 1 {
+		client_trans.Write(bytes.Repeat([]byte{byte(i)}, client_trans.MTU()))
+	}
+	timeout := time.After(3 * time.Second)
+	for i := 0; i < count; {
+		select {
+		case data := <-echo.received:
+			if len(data) != client_trans.MTU() {
+				// echo of hello retransmitted
+				continue
+			}
+			if data[0] != byte(i) {
+				t.Fatalf("Expect packet %v, got %v", i, data[0])
+			}
+			i += 1
+		case <-timeout:
+			t.Fatalf("Only %v of %v packets echoed", i, count)
+		}
+	}
+}